@@ -0,0 +1,73 @@
+package rawpb
+
+// AppendTag appends the tag of field num with wire type wt to b.
+func AppendTag(b []byte, num, wt int) []byte {
+	return AppendVarint(b, uint64(num)<<3|uint64(wt))
+}
+
+// AppendVarint appends v to b as a varint.
+func AppendVarint(b []byte, v uint64) []byte {
+	for v >= 1<<7 {
+		b = append(b, byte(v&0x7f|0x80))
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// AppendFixed64 appends v to b as 8 little-endian bytes.
+func AppendFixed64(b []byte, v uint64) []byte {
+	return append(b,
+		byte(v), byte(v>>8), byte(v>>16), byte(v>>24),
+		byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
+
+// AppendFixed32 appends v to b as 4 little-endian bytes.
+func AppendFixed32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// AppendBytes appends v to b prefixed with its length, the value of a
+// length-delimited field.
+func AppendBytes(b []byte, v []byte) []byte {
+	b = AppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendString is AppendBytes for a string.
+func AppendString(b []byte, v string) []byte {
+	b = AppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendMessage appends submessage field num to b. f appends the body of
+// the submessage to the slice it is given and returns the result; the
+// length in front of the body is patched in afterwards. A body of 128 bytes
+// or more is moved to make room for its length, at every nesting level;
+// Encoder moves each byte at most once however deep messages nest.
+func AppendMessage(b []byte, num int, f func(b []byte) []byte) []byte {
+	b, start := beginMessage(b, num)
+	return endMessage(f(b), start)
+}
+
+// beginMessage appends the tag of submessage field num and one byte of
+// room for its length. start is where the body begins.
+func beginMessage(b []byte, num int) (_ []byte, start int) {
+	b = AppendTag(b, num, WireLen)
+	b = append(b, 0)
+	return b, len(b)
+}
+
+// endMessage writes the length of the body from start to the end of b in
+// front of it. The body moves only if the length needs more than the one
+// byte beginMessage reserved.
+func endMessage(b []byte, start int) []byte {
+	var l [maxVarintBytes]byte
+	size := len(b) - start
+	n := len(AppendVarint(l[:0], uint64(size)))
+	if n > 1 {
+		b = append(b, l[:n-1]...) // grow by n-1
+		copy(b[start+n-1:], b[start:start+size])
+	}
+	copy(b[start-1:], l[:n])
+	return b
+}
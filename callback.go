@@ -2,6 +2,7 @@ package rawpb
 
 import (
 	"fmt"
+	"io"
 	"slices"
 )
 
@@ -16,6 +17,7 @@ const (
 	callbackTypeFixed32 callbackType = 3
 	callbackTypeBytes   callbackType = 4
 	callbackTypeMessage callbackType = 5
+	callbackTypeRaw     callbackType = 6 // any wire type, encoded field
 )
 
 var callbackTypeString = map[callbackType]string{
@@ -25,6 +27,7 @@ var callbackTypeString = map[callbackType]string{
 	callbackTypeFixed32: "fixed32",
 	callbackTypeBytes:   "length-delimited",
 	callbackTypeMessage: "length-delimited",
+	callbackTypeRaw:     "raw",
 }
 
 type callback struct {
@@ -33,11 +36,23 @@ type callback struct {
 	funcUint32 func(v uint32) error
 	funcBytes  func(v []byte) error
 	message    *RawPB
+
+	// funcPacked, when set on a varint or fixed callback, receives a whole
+	// packed payload instead of one funcUint64/funcUint32 call per element.
+	funcPacked func(v []byte) error
+
+	// funcReader, when set on a bytes callback, streams the payload
+	// instead of handing it over as a slice.
+	funcReader func(r io.Reader, n uint64) error
+
+	// signed, when set on a signed integer callback, converts a wire value
+	// to the integer it encodes, for Canonicalize to order map keys by.
+	signed func(v uint64) int64
 }
 
 type callbacks struct {
-	lst []callback       // fields 1-128
-	mp  map[int]callback // fields 129+
+	lst []callback        // fields 1-128
+	mp  map[int]*callback // fields 129+
 
 	unknown struct { // defaults
 		varint  func(num int, v uint64) error
@@ -55,9 +70,9 @@ func (cb *callbacks) set(num int, c callback) {
 	}
 	if num > maxFieldListItems {
 		if cb.mp == nil {
-			cb.mp = make(map[int]callback)
+			cb.mp = make(map[int]*callback)
 		}
-		cb.mp[num] = c
+		cb.mp[num] = &c
 		return
 	}
 
@@ -94,6 +109,15 @@ func (cb *callbacks) setFixed32(num int, f func(v uint32) error) {
 	})
 }
 
+func (cb *callbacks) setPacked(num int, tp callbackType, f64 func(v uint64) error, f32 func(v uint32) error, packed func(v []byte) error) {
+	cb.set(num, callback{
+		tp:         tp,
+		funcUint64: f64,
+		funcUint32: f32,
+		funcPacked: packed,
+	})
+}
+
 func (cb *callbacks) setMessage(num int, m *RawPB) {
 	cb.set(num, callback{
 		tp:      callbackTypeMessage,
@@ -108,24 +132,56 @@ func (cb *callbacks) setBytes(num int, f func(v []byte) error) {
 	})
 }
 
+func (cb *callbacks) setBytesReader(num int, f func(r io.Reader, n uint64) error) {
+	cb.set(num, callback{
+		tp:         callbackTypeBytes,
+		funcReader: f,
+	})
+}
+
+func (cb *callbacks) setRaw(num int, f func(v []byte) error) {
+	cb.set(num, callback{
+		tp:        callbackTypeRaw,
+		funcBytes: f,
+	})
+}
+
+// setSigned marks the callback registered for num as a signed integer.
+func (cb *callbacks) setSigned(num int, signed func(v uint64) int64) {
+	if num > maxFieldListItems {
+		cb.mp[num].signed = signed
+		return
+	}
+	cb.lst[num-1].signed = signed
+}
+
 func (c *callback) wireType() string {
 	return callbackTypeString[c.tp]
 }
 
 func (cb *callbacks) get(num int) callback {
+	return *cb.lookup(num)
+}
+
+// lookup is get without copying the callback, for the parse loops. The
+// result must not be modified.
+func (cb *callbacks) lookup(num int) *callback {
 	if num <= 0 {
-		return emptyCallback
+		return &emptyCallback
 	}
 
 	if num > maxFieldListItems {
-		return cb.mp[num]
+		if c := cb.mp[num]; c != nil {
+			return c
+		}
+		return &emptyCallback
 	}
 
 	if num <= len(cb.lst) {
-		return cb.lst[num-1]
+		return &cb.lst[num-1]
 	}
 
-	return emptyCallback
+	return &emptyCallback
 }
 
 func call[T any](f func(v T) error, v T) error {
@@ -142,35 +198,32 @@ func callUnknown[T any](f func(num int, v T) error, num int, v T) error {
 	return f(num, v)
 }
 
-func (cb *callbacks) varint(num int, v uint64) error {
-	c := cb.get(num)
+func (cb *callbacks) varint(c *callback, num int, v uint64) error {
 	if c.tp == callbackTypeNone {
 		return callUnknown(cb.unknown.varint, num, v)
 	}
 	if c.tp == callbackTypeVarint {
 		return call(c.funcUint64, v)
 	}
-	return fmt.Errorf("field %d: varint received, but %s expected", num, c.wireType())
+	return fmt.Errorf("field %d: varint received, but %s expected: %w", num, c.wireType(), ErrorWrongWireType)
 }
 
-func (cb *callbacks) fixed64(num int, v uint64) error {
-	c := cb.get(num)
+func (cb *callbacks) fixed64(c *callback, num int, v uint64) error {
 	if c.tp == callbackTypeNone {
 		return callUnknown(cb.unknown.fixed64, num, v)
 	}
 	if c.tp == callbackTypeFixed64 {
 		return call(c.funcUint64, v)
 	}
-	return fmt.Errorf("field %d: fixed64 received, but %s expected", num, c.wireType())
+	return fmt.Errorf("field %d: fixed64 received, but %s expected: %w", num, c.wireType(), ErrorWrongWireType)
 }
 
-func (cb *callbacks) fixed32(num int, v uint32) error {
-	c := cb.get(num)
+func (cb *callbacks) fixed32(c *callback, num int, v uint32) error {
 	if c.tp == callbackTypeNone {
 		return callUnknown(cb.unknown.fixed32, num, v)
 	}
 	if c.tp == callbackTypeFixed32 {
 		return call(c.funcUint32, v)
 	}
-	return fmt.Errorf("field %d: fixed32 received, but %s expected", num, c.wireType())
+	return fmt.Errorf("field %d: fixed32 received, but %s expected: %w", num, c.wireType(), ErrorWrongWireType)
 }
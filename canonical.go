@@ -0,0 +1,382 @@
+package rawpb
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// MapEntry marks a message schema as the entry of a map field, with the
+// key in field 1 and the value in field 2. Parse ignores it; Canonicalize
+// sorts the entries of such fields by key.
+func MapEntry() Option {
+	return func(p *RawPB) {
+		p.mapEntry = true
+	}
+}
+
+// Repeated marks field num as repeated for Canonicalize, which keeps all
+// of its occurrences and writes scalar values unpacked. Parse ignores it:
+// it hands over every occurrence of every field.
+func Repeated(num int) Option {
+	return func(p *RawPB) {
+		p.setRepeated(num, false)
+	}
+}
+
+// Packed marks scalar field num as packed repeated for Canonicalize, which
+// writes all of its values as one packed field, the proto3 default for
+// repeated scalars. Fields registered with a Packed option such as
+// PackedUint64s are packed without it. Parse ignores it.
+func Packed(num int) Option {
+	return func(p *RawPB) {
+		p.setRepeated(num, true)
+	}
+}
+
+func (p *RawPB) setRepeated(num int, packed bool) {
+	if p.repeated == nil {
+		p.repeated = make(map[int]bool)
+	}
+	p.repeated[num] = packed
+}
+
+// Canonicalize rewrites an encoded message into a canonical form, so that
+// encodings of the same message compare equal byte for byte:
+//
+//   - fields are sorted by number, repeated fields keeping their order;
+//   - varints, tags and lengths use the fewest bytes;
+//   - fields marked Packed, or registered with a Packed option, are packed
+//     into one field; scalar fields marked Repeated are unpacked;
+//   - other fields the schema registers are singular: the last occurrence
+//     of a scalar, string or bytes field wins, and the occurrences of a
+//     message field are merged into one, as parsers merge them;
+//   - entries of map fields, whose message schema has the MapEntry option,
+//     are sorted by key, one per key (the last one wins), with both key
+//     and value written. Keys sort as Deterministic sorts them, by the
+//     type the entry schema declares for field 1: signed integers such as
+//     Int64, Sint32 and Sfixed64 in signed order, other numbers unsigned.
+//
+// Only the schema's field types and markers are used, not its callbacks. Fields the
+// schema does not know are written with minimal varints but otherwise as
+// they are: an unknown length-delimited field may hold a message, but its
+// contents are not canonicalized. Canonicalize fails with the error Parse
+// would return for wire types that do not match the schema, and for
+// malformed input. A nil schema knows no fields.
+func Canonicalize(body []byte, schema *RawPB) ([]byte, error) {
+	if schema == nil {
+		schema = New()
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(body))
+	err := Write(&buf, func(w *Writer) error {
+		return canonicalMessage(w, body, schema)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// canonicalField is a field of the message being canonicalized: its
+// scalar value, or the payload of a length-delimited field.
+type canonicalField struct {
+	num     int
+	wt      int
+	scalar  uint64
+	payload []byte
+}
+
+// canonicalMessage writes the fields of body in canonical form to w.
+func canonicalMessage(w *Writer, body []byte, pb *RawPB) error {
+	var fields []canonicalField
+	var d Decoder
+	d.Reset(body)
+	for d.Next() {
+		f := canonicalField{num: d.Num(), wt: d.WireType()}
+		switch f.wt {
+		case WireVarint:
+			f.scalar = d.Uint64()
+		case WireFixed64:
+			f.scalar = d.Fixed64()
+		case WireFixed32:
+			f.scalar = uint64(d.Fixed32())
+		case WireLen:
+			f.payload = d.Bytes()
+		}
+		fields = append(fields, f)
+	}
+	if err := d.Err(); err != nil {
+		return err
+	}
+	if pb.mapEntry {
+		fields = entryDefaults(fields, pb)
+	}
+
+	slices.SortStableFunc(fields, func(a, b canonicalField) int {
+		return cmp.Compare(a.num, b.num)
+	})
+	for len(fields) > 0 {
+		n := 1
+		for n < len(fields) && fields[n].num == fields[0].num {
+			n++
+		}
+		if err := canonicalRepeated(w, fields[:n], pb); err != nil {
+			return pb.wrapError(fields[0].num, err)
+		}
+		fields = fields[n:]
+	}
+	return nil
+}
+
+// canonicalRepeated writes the occurrences of one field number.
+func canonicalRepeated(w *Writer, fields []canonicalField, pb *RawPB) error {
+	num := fields[0].num
+	c := pb.schema.get(num)
+	packed, repeated := pb.repeated[num]
+	if c.funcPacked != nil {
+		packed, repeated = true, true
+	}
+
+	switch c.tp {
+	case callbackTypeNone, callbackTypeRaw:
+		for _, f := range fields {
+			switch f.wt {
+			case WireVarint:
+				w.Uint64(num, f.scalar)
+			case WireFixed64:
+				w.Fixed64(num, f.scalar)
+			case WireFixed32:
+				w.Fixed32(num, uint32(f.scalar))
+			case WireLen:
+				w.Bytes(num, f.payload)
+			}
+		}
+		return w.Err()
+	case callbackTypeBytes, callbackTypeMessage:
+		for _, f := range fields {
+			if f.wt != WireLen {
+				return wrongWireType(c, num, f.wt)
+			}
+		}
+		if c.message == nil {
+			if !repeated {
+				fields = fields[len(fields)-1:]
+			}
+			for _, f := range fields {
+				w.Bytes(num, f.payload)
+			}
+			return w.Err()
+		}
+		if c.message.mapEntry {
+			return canonicalMap(w, fields, c.message)
+		}
+		if !repeated && len(fields) > 1 {
+			// a message is the concatenation of its occurrences
+			var merged []byte
+			for _, f := range fields {
+				merged = append(merged, f.payload...)
+			}
+			fields = []canonicalField{{num: num, wt: WireLen, payload: merged}}
+		}
+		for _, f := range fields {
+			w.Message(num, func(w *Writer) error {
+				return canonicalMessage(w, f.payload, c.message)
+			})
+		}
+		return w.Err()
+	}
+
+	// scalars, packed or not
+	var values []uint64
+	for _, f := range fields {
+		if f.wt == WireLen {
+			if c.funcPacked != nil && len(f.payload)%fixedSize(c.tp) != 0 {
+				// as PackedDoubles and PackedFloats report it
+				return ErrorInvalidMessage
+			}
+			var err error
+			if values, err = unpackValues(values, f.payload, c.tp); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.wt != scalarWireType(c.tp) {
+			return wrongWireType(c, num, f.wt)
+		}
+		values = append(values, f.scalar)
+	}
+	if !repeated && len(values) > 1 {
+		values = values[len(values)-1:]
+	}
+
+	if packed {
+		switch c.tp {
+		case callbackTypeVarint:
+			w.PackedUint64(num, values)
+		case callbackTypeFixed64:
+			w.PackedFixed64(num, values)
+		case callbackTypeFixed32:
+			v32 := make([]uint32, len(values))
+			for i, v := range values {
+				v32[i] = uint32(v)
+			}
+			w.PackedFixed32(num, v32)
+		}
+		return w.Err()
+	}
+	for _, v := range values {
+		switch c.tp {
+		case callbackTypeVarint:
+			w.Uint64(num, v)
+		case callbackTypeFixed64:
+			w.Fixed64(num, v)
+		case callbackTypeFixed32:
+			w.Fixed32(num, uint32(v))
+		}
+	}
+	return w.Err()
+}
+
+// fixedSize is the size of one packed value of type tp, or 1 for varints.
+func fixedSize(tp callbackType) int {
+	switch tp {
+	case callbackTypeFixed64:
+		return 8
+	case callbackTypeFixed32:
+		return 4
+	}
+	return 1
+}
+
+// unpackValues appends the values of a packed payload to values.
+func unpackValues(values []uint64, b []byte, tp callbackType) ([]uint64, error) {
+	for len(b) > 0 {
+		var v uint64
+		var n int
+		var err error
+		switch tp {
+		case callbackTypeVarint:
+			v, n, err = decodeVarint(b)
+		case callbackTypeFixed64:
+			v, err = decodeFixed64(b)
+			n = 8
+		case callbackTypeFixed32:
+			var v32 uint32
+			v32, err = decodeFixed32(b)
+			v, n = uint64(v32), 4
+		}
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+		b = b[n:]
+	}
+	return values, nil
+}
+
+// canonicalEntry is a map entry with its key.
+type canonicalEntry struct {
+	scalar  uint64 // numeric or bool key
+	signed  int64  // scalar as a signed key
+	key     []byte // string or bytes key
+	payload []byte
+}
+
+// canonicalMap writes the entries of a map field sorted by key.
+func canonicalMap(w *Writer, fields []canonicalField, entry *RawPB) error {
+	num := fields[0].num
+	signed := entry.schema.get(1).signed
+	entries := make([]canonicalEntry, 0, len(fields))
+	for _, f := range fields {
+		e := canonicalEntry{payload: f.payload}
+		var d Decoder
+		d.Reset(f.payload)
+		for d.Next() {
+			if d.Num() != 1 {
+				continue
+			}
+			switch d.WireType() {
+			case WireVarint:
+				e.scalar = d.Uint64()
+			case WireFixed64:
+				e.scalar = d.Fixed64()
+			case WireFixed32:
+				e.scalar = uint64(d.Fixed32())
+			case WireLen:
+				e.key = d.Bytes()
+			}
+		}
+		if err := d.Err(); err != nil {
+			return err
+		}
+		if signed != nil {
+			e.signed = signed(e.scalar)
+		}
+		entries = append(entries, e)
+	}
+
+	compare := func(a, b canonicalEntry) int {
+		if signed != nil {
+			return cmp.Compare(a.signed, b.signed)
+		}
+		if c := cmp.Compare(a.scalar, b.scalar); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.key, b.key)
+	}
+	slices.SortStableFunc(entries, compare)
+	for i, e := range entries {
+		if i+1 < len(entries) && compare(e, entries[i+1]) == 0 {
+			continue // a later entry has the same key
+		}
+		w.Message(num, func(w *Writer) error {
+			return canonicalMessage(w, e.payload, entry)
+		})
+	}
+	return w.Err()
+}
+
+// entryDefaults adds the key and value of a map entry when they are
+// missing, with their default values, as encoders write both.
+func entryDefaults(fields []canonicalField, entry *RawPB) []canonicalField {
+	for num := 1; num <= 2; num++ {
+		if slices.ContainsFunc(fields, func(f canonicalField) bool { return f.num == num }) {
+			continue
+		}
+		switch c := entry.schema.get(num); c.tp {
+		case callbackTypeVarint, callbackTypeFixed64, callbackTypeFixed32:
+			fields = append(fields, canonicalField{num: num, wt: scalarWireType(c.tp)})
+		case callbackTypeBytes, callbackTypeMessage:
+			fields = append(fields, canonicalField{num: num, wt: WireLen})
+		}
+	}
+	return fields
+}
+
+// scalarWireType returns the wire type of an unpacked scalar callback.
+func scalarWireType(tp callbackType) int {
+	switch tp {
+	case callbackTypeFixed64:
+		return WireFixed64
+	case callbackTypeFixed32:
+		return WireFixed32
+	}
+	return WireVarint
+}
+
+// wrongWireType is the error Parse returns for a field of wire type wt
+// registered as c.
+func wrongWireType(c callback, num, wt int) error {
+	received := "length-delimited"
+	switch wt {
+	case WireVarint:
+		received = "varint"
+	case WireFixed64:
+		received = "fixed64"
+	case WireFixed32:
+		received = "fixed32"
+	}
+	return fmt.Errorf("field %d: %s received, but %s expected: %w", num, received, c.wireType(), ErrorWrongWireType)
+}
@@ -0,0 +1,422 @@
+package rawpb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// compiledOp is what the compiled decoder does for one (field, wire type)
+// pair. Resolving it ahead of time replaces the wire type switch, the
+// callbacks lookup and the callback type switch of Parse with one switch.
+type compiledOp uint8
+
+const (
+	opInvalidNum compiledOp = iota // field number 0
+	opWrongWireType
+	opVarint
+	opFixed64
+	opFixed32
+	opBytes
+	opBytesReader
+	opMessage
+	opPackedVarint
+	opPackedFixed64
+	opPackedFixed32
+	opPackedBatch
+	opRaw
+	opSkipBytes
+	opUnknownVarint
+	opUnknownFixed64
+	opUnknownFixed32
+	opUnknownBytes
+	opMismatchVarint
+	opMismatchFixed64
+	opMismatchFixed32
+)
+
+type compiledField struct {
+	callback
+	child int32 // index into Compiled.msgs, -1 if the message is nil
+}
+
+type compiledEntry struct {
+	op    compiledOp
+	field *compiledField
+}
+
+type compiledMessage struct {
+	pb     *RawPB
+	short  [128]compiledEntry // keyed by single-byte tag (fields 1-15)
+	fields []compiledField    // fields 1-128
+	mp     map[int]*compiledField
+}
+
+// compiledFrame is a message the decoder descended from.
+type compiledFrame struct {
+	msg *compiledMessage
+	end int
+	num int // field number of the submessage in msg
+}
+
+// Compiled is a RawPB schema tree flattened into one dispatch table. It
+// decodes the same input into the same callbacks as Parse, with the same
+// errors, but walks nested messages in a single loop instead of recursing
+// and matches single-byte tags with one table lookup.
+//
+// Compiled is a snapshot: options applied to the schema after Compile are
+// not seen. The callbacks themselves are shared with the schema.
+type Compiled struct {
+	msgs []compiledMessage
+}
+
+// Compile flattens pb and all its nested message schemas into a Compiled
+// decoder. Schemas reachable through several fields are compiled once.
+func (pb *RawPB) Compile() *Compiled {
+	c := &Compiled{}
+	index := make(map[*RawPB]int32)
+	c.add(pb, index)
+	for i := range c.msgs {
+		c.msgs[i].build(c.msgs, index)
+	}
+	return c
+}
+
+func (c *Compiled) add(pb *RawPB, index map[*RawPB]int32) int32 {
+	if i, ok := index[pb]; ok {
+		return i
+	}
+	i := int32(len(c.msgs))
+	index[pb] = i
+	c.msgs = append(c.msgs, compiledMessage{pb: pb})
+
+	for _, cb := range pb.schema.lst {
+		if cb.tp == callbackTypeMessage && cb.message != nil {
+			c.add(cb.message, index)
+		}
+	}
+	for _, cb := range pb.schema.mp {
+		if cb.tp == callbackTypeMessage && cb.message != nil {
+			c.add(cb.message, index)
+		}
+	}
+	return i
+}
+
+func (m *compiledMessage) build(msgs []compiledMessage, index map[*RawPB]int32) {
+	compile := func(cb callback) compiledField {
+		f := compiledField{callback: cb, child: -1}
+		if cb.tp == callbackTypeMessage && cb.message != nil {
+			f.child = index[cb.message]
+		}
+		return f
+	}
+
+	m.fields = make([]compiledField, len(m.pb.schema.lst))
+	for i, cb := range m.pb.schema.lst {
+		m.fields[i] = compile(cb)
+	}
+	if len(m.pb.schema.mp) > 0 {
+		m.mp = make(map[int]*compiledField, len(m.pb.schema.mp))
+		for num, cb := range m.pb.schema.mp {
+			f := compile(*cb)
+			m.mp[num] = &f
+		}
+	}
+
+	for tag := range m.short {
+		m.short[tag] = m.lookup(uint64(tag))
+	}
+}
+
+// lookup resolves the entry for a tag.
+func (m *compiledMessage) lookup(tag uint64) compiledEntry {
+	num, _, err := parseTag(tag)
+	if err != nil {
+		return compiledEntry{op: opInvalidNum}
+	}
+
+	var f *compiledField
+	if num > maxFieldListItems {
+		f = m.mp[num]
+	} else if num <= len(m.fields) {
+		f = &m.fields[num-1]
+	}
+	tp := callbackTypeNone
+	if f != nil {
+		tp = f.tp
+	}
+
+	if tp == callbackTypeRaw {
+		switch tag & 7 {
+		case 0, 1, 2, 5:
+			return compiledEntry{op: opRaw, field: f}
+		}
+		return compiledEntry{op: opWrongWireType}
+	}
+
+	switch tag & 7 {
+	case 0:
+		switch tp {
+		case callbackTypeNone:
+			return compiledEntry{op: opUnknownVarint}
+		case callbackTypeVarint:
+			return compiledEntry{op: opVarint, field: f}
+		}
+		return compiledEntry{op: opMismatchVarint, field: f}
+	case 1:
+		switch tp {
+		case callbackTypeNone:
+			return compiledEntry{op: opUnknownFixed64}
+		case callbackTypeFixed64:
+			return compiledEntry{op: opFixed64, field: f}
+		}
+		return compiledEntry{op: opMismatchFixed64, field: f}
+	case 5:
+		switch tp {
+		case callbackTypeNone:
+			return compiledEntry{op: opUnknownFixed32}
+		case callbackTypeFixed32:
+			return compiledEntry{op: opFixed32, field: f}
+		}
+		return compiledEntry{op: opMismatchFixed32, field: f}
+	case 2:
+		if f != nil && f.funcPacked != nil {
+			return compiledEntry{op: opPackedBatch, field: f}
+		}
+		switch tp {
+		case callbackTypeNone:
+			return compiledEntry{op: opUnknownBytes}
+		case callbackTypeBytes:
+			if f.funcReader != nil {
+				return compiledEntry{op: opBytesReader, field: f}
+			}
+			if f.funcBytes == nil {
+				return compiledEntry{op: opSkipBytes}
+			}
+			return compiledEntry{op: opBytes, field: f}
+		case callbackTypeMessage:
+			if f.child < 0 {
+				return compiledEntry{op: opSkipBytes}
+			}
+			return compiledEntry{op: opMessage, field: f}
+		case callbackTypeVarint:
+			return compiledEntry{op: opPackedVarint, field: f}
+		case callbackTypeFixed64:
+			return compiledEntry{op: opPackedFixed64, field: f}
+		case callbackTypeFixed32:
+			return compiledEntry{op: opPackedFixed32, field: f}
+		}
+		panic("unknown callback type")
+	}
+	return compiledEntry{op: opWrongWireType}
+}
+
+// Parse decodes body like the Parse method of the compiled schema.
+func (c *Compiled) Parse(body []byte) error {
+	var stackBuf [8]compiledFrame
+	stack := stackBuf[:0]
+
+	m := &c.msgs[0]
+	if m.pb.maxSize > 0 && uint64(len(body)) > m.pb.maxSize {
+		return ErrorTooLarge
+	}
+	if m.pb.beginFunc != nil {
+		if err := m.pb.beginFunc(); err != nil {
+			return err
+		}
+	}
+
+	off, end := 0, len(body)
+	for {
+		if off >= end {
+			if m.pb.endFunc != nil {
+				if err := m.pb.endFunc(); err != nil {
+					return unwindCompiled(stack, err)
+				}
+			}
+			if len(stack) == 0 {
+				return nil
+			}
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			m, end = top.msg, top.end
+			continue
+		}
+
+		start := off
+		var tag uint64
+		var e compiledEntry
+		if b := body[off]; b < 0x80 {
+			tag = uint64(b)
+			e = m.short[b]
+			off++
+		} else {
+			v, n, err := decodeVarint(body[off:end])
+			if err != nil {
+				return unwindCompiled(stack, err)
+			}
+			tag = v
+			e = m.lookup(tag)
+			off += n
+		}
+		num := int(tag >> 3)
+
+		var err error
+		switch e.op {
+		case opInvalidNum:
+			err = ErrorInvalidMessage
+		case opWrongWireType:
+			return unwindCompiled(stack, ErrorWrongWireType)
+
+		case opRaw:
+			var n int
+			if n, err = valueSize(body[off:end], int(tag&7)); err != nil {
+				break
+			}
+			off += n
+			err = call(e.field.funcBytes, body[start:off])
+
+		case opVarint, opUnknownVarint, opMismatchVarint:
+			var v uint64
+			if off < end && body[off] < 0x80 {
+				v = uint64(body[off])
+				off++
+			} else {
+				var n int
+				if v, n, err = decodeVarint(body[off:end]); err != nil {
+					break
+				}
+				off += n
+			}
+			if e.op == opVarint {
+				err = call(e.field.funcUint64, v)
+			} else if e.op == opUnknownVarint {
+				err = callUnknown(m.pb.schema.unknown.varint, num, v)
+			} else {
+				err = mismatchError(num, "varint", e.field)
+			}
+
+		case opFixed64, opUnknownFixed64, opMismatchFixed64:
+			if end-off < 8 {
+				err = ErrorTruncated
+				break
+			}
+			v := binary.LittleEndian.Uint64(body[off:])
+			off += 8
+			if e.op == opFixed64 {
+				err = call(e.field.funcUint64, v)
+			} else if e.op == opUnknownFixed64 {
+				err = callUnknown(m.pb.schema.unknown.fixed64, num, v)
+			} else {
+				err = mismatchError(num, "fixed64", e.field)
+			}
+
+		case opFixed32, opUnknownFixed32, opMismatchFixed32:
+			if end-off < 4 {
+				err = ErrorTruncated
+				break
+			}
+			v := binary.LittleEndian.Uint32(body[off:])
+			off += 4
+			if e.op == opFixed32 {
+				err = call(e.field.funcUint32, v)
+			} else if e.op == opUnknownFixed32 {
+				err = callUnknown(m.pb.schema.unknown.fixed32, num, v)
+			} else {
+				err = mismatchError(num, "fixed32", e.field)
+			}
+
+		default: // length-delimited
+			var l uint64
+			if off < end && body[off] < 0x80 {
+				l = uint64(body[off])
+				off++
+			} else {
+				var n int
+				if l, n, err = decodeVarint(body[off:end]); err != nil {
+					break
+				}
+				off += n
+			}
+			if l > uint64(end-off) {
+				err = ErrorTruncated
+				break
+			}
+
+			if e.op == opMessage {
+				stack = append(stack, compiledFrame{msg: m, end: end, num: num})
+				m = &c.msgs[e.field.child]
+				end = off + int(l)
+				if m.pb.maxSize > 0 && l > m.pb.maxSize {
+					return unwindCompiled(stack, ErrorTooLarge)
+				}
+				if m.pb.beginFunc != nil {
+					if err := m.pb.beginFunc(); err != nil {
+						return unwindCompiled(stack, err)
+					}
+				}
+				continue
+			}
+
+			v := body[off : off+int(l)]
+			off += int(l)
+			switch e.op {
+			case opBytes:
+				err = e.field.funcBytes(v)
+			case opBytesReader:
+				err = e.field.funcReader(bytes.NewReader(v), l)
+			case opUnknownBytes:
+				err = callUnknown(m.pb.schema.unknown.bytes, num, v)
+			case opPackedBatch:
+				err = e.field.funcPacked(v)
+			case opPackedVarint:
+				for len(v) > 0 && err == nil {
+					vv, n, verr := decodeVarint(v)
+					if verr != nil {
+						err = verr
+						break
+					}
+					v = v[n:]
+					err = call(e.field.funcUint64, vv)
+				}
+			case opPackedFixed64:
+				for len(v) > 0 && err == nil {
+					if len(v) < 8 {
+						err = ErrorTruncated
+						break
+					}
+					err = call(e.field.funcUint64, binary.LittleEndian.Uint64(v))
+					v = v[8:]
+				}
+			case opPackedFixed32:
+				for len(v) > 0 && err == nil {
+					if len(v) < 4 {
+						err = ErrorTruncated
+						break
+					}
+					err = call(e.field.funcUint32, binary.LittleEndian.Uint32(v))
+					v = v[4:]
+				}
+			}
+		}
+
+		if err != nil {
+			return unwindCompiled(stack, m.pb.wrapError(num, err))
+		}
+	}
+}
+
+// mismatchError matches the errors of callbacks.varint, fixed64 and fixed32.
+func mismatchError(num int, received string, f *compiledField) error {
+	return fmt.Errorf("field %d: %s received, but %s expected: %w", num, received, f.wireType(), ErrorWrongWireType)
+}
+
+// unwindCompiled wraps err with the field of every enclosing message, the
+// way nested Parse calls do on the way out.
+func unwindCompiled(stack []compiledFrame, err error) error {
+	for i := len(stack) - 1; i >= 0; i-- {
+		err = stack[i].msg.pb.wrapError(stack[i].num, err)
+	}
+	return err
+}
@@ -60,10 +60,9 @@ const (
 //
 // Concurrent use from multiple goroutines is not supported.
 type Decoder struct {
-	body   []byte
-	offset int    // where Next() reads the next tag from
-	scalar uint64 // last-decoded value for varint / fixed32 / fixed64
-	slice  []byte // last-decoded payload for length-delimited
+	r      readerBody // the input, read with the same cursor as Parse
+	scalar uint64     // last-decoded value for varint / fixed32 / fixed64
+	slice  []byte     // last-decoded payload for length-delimited
 	num    int
 	wt     int
 	err    error
@@ -79,13 +78,12 @@ type Decoder struct {
 
 // NewDecoder returns a Decoder over body. Equivalent to (&Decoder{}).Reset(body).
 func NewDecoder(body []byte) *Decoder {
-	return &Decoder{body: body}
+	return &Decoder{r: newReaderBody(body)}
 }
 
 // Reset rebinds the decoder to a new input and clears prior state.
 func (d *Decoder) Reset(body []byte) {
-	d.body = body
-	d.offset = 0
+	d.r = newReaderBody(body)
 	d.scalar = 0
 	d.slice = nil
 	d.num = 0
@@ -134,21 +132,19 @@ func (d *Decoder) Next() bool {
 	// Not in packed mode (or just consumed the last packed value).
 	d.packedRem = nil
 
-	if d.offset >= len(d.body) {
+	if d.r.offset >= d.r.end {
 		return false
 	}
-
-	tag, n, err := decodeVarint(d.body[d.offset:])
+	d.r.start = d.r.offset
+	tag, err := d.r.varint()
 	if err != nil {
 		d.err = err
 		return false
 	}
-	d.offset += n
 
-	wt := int(tag & 7)
-	num := int(tag >> 3)
-	if num < 1 || num > maxFieldNumber {
-		d.err = ErrorInvalidMessage
+	num, wt, err := parseTag(tag)
+	if err != nil {
+		d.err = err
 		return false
 	}
 	d.num = num
@@ -157,50 +153,23 @@ func (d *Decoder) Next() bool {
 
 	switch wt {
 	case WireVarint:
-		v, vn, err := decodeVarint(d.body[d.offset:])
-		if err != nil {
-			d.err = err
-			return false
-		}
-		d.scalar = v
-		d.offset += vn
+		d.scalar, err = d.r.varint()
 	case WireFixed64:
-		if d.offset+8 > len(d.body) {
-			d.err = ErrorTruncated
-			return false
-		}
-		b := d.body[d.offset:]
-		d.scalar = uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
-			uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
-		d.offset += 8
+		d.scalar, err = d.r.fixed64()
 	case WireFixed32:
-		if d.offset+4 > len(d.body) {
-			d.err = ErrorTruncated
-			return false
-		}
-		b := d.body[d.offset:]
-		d.scalar = uint64(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24)
-		d.offset += 4
+		var v uint32
+		v, err = d.r.fixed32()
+		d.scalar = uint64(v)
 	case WireLen:
-		l, ln, err := decodeVarint(d.body[d.offset:])
-		if err != nil {
-			d.err = err
-			return false
-		}
-		d.offset += ln
-		if l > uint64(math.MaxInt) {
-			d.err = ErrorInvalidMessage
-			return false
+		var l uint64
+		if l, err = d.r.varint(); err == nil {
+			d.slice, err = d.r.bytes(l)
 		}
-		if uint64(len(d.body)-d.offset) < l {
-			d.err = ErrorTruncated
-			return false
-		}
-		end := d.offset + int(l)
-		d.slice = d.body[d.offset:end]
-		d.offset = end
 	default:
-		d.err = ErrorWrongWireType
+		err = ErrorWrongWireType
+	}
+	if err != nil {
+		d.err = err
 		return false
 	}
 	return true
@@ -223,44 +192,41 @@ func (d *Decoder) nextPackedValue() bool {
 		d.scalar = v
 		d.packedRem = d.packedRem[n:]
 	case WireFixed64:
-		if len(d.packedRem) < 8 {
-			d.err = ErrorTruncated
+		v, err := decodeFixed64(d.packedRem)
+		if err != nil {
+			d.err = err
 			return false
 		}
-		b := d.packedRem
-		d.scalar = uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
-			uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+		d.scalar = v
 		d.packedRem = d.packedRem[8:]
 	case WireFixed32:
-		if len(d.packedRem) < 4 {
-			d.err = ErrorTruncated
+		v, err := decodeFixed32(d.packedRem)
+		if err != nil {
+			d.err = err
 			return false
 		}
-		b := d.packedRem
-		d.scalar = uint64(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24)
+		d.scalar = uint64(v)
 		d.packedRem = d.packedRem[4:]
 	}
 	return true
 }
 
-// decodeVarint reads a varint from the start of b, returning the value and
-// the number of bytes consumed. Rejects varints longer than 10 bytes.
-func decodeVarint(b []byte) (uint64, int, error) {
-	var v uint64
-	limit := len(b)
-	if limit > maxVarintBytes {
-		limit = maxVarintBytes
-	}
-	for i := 0; i < limit; i++ {
-		v |= uint64(b[i]&0x7f) << (7 * uint(i))
-		if b[i]&0x80 == 0 {
-			return v, i + 1, nil
-		}
-	}
-	if len(b) < maxVarintBytes {
-		return 0, 0, ErrorTruncated
+// Offset returns the position in the input where the current field starts,
+// that is the offset of its tag. Inside packed continuation it is the start
+// of the enclosing length-delimited field.
+func (d *Decoder) Offset() int {
+	return d.r.start
+}
+
+// RawField returns the complete encoding of the current field, tag
+// included, as a subslice of the input. Writing it with Writer.Raw
+// reproduces the field byte for byte. Inside packed continuation it is the
+// whole packed field. RawField returns nil after an error.
+func (d *Decoder) RawField() []byte {
+	if d.err != nil {
+		return nil
 	}
-	return 0, 0, ErrorInvalidMessage
+	return d.r.body[d.r.start:d.r.offset]
 }
 
 // --- Varint accessors ---
@@ -483,5 +449,5 @@ func (d *Decoder) Submessage() Decoder {
 	if b == nil {
 		return Decoder{}
 	}
-	return Decoder{body: b}
+	return Decoder{r: newReaderBody(b)}
 }
@@ -0,0 +1,222 @@
+package rawpb
+
+import "math"
+
+// Encoder appends protobuf fields to a byte slice. It has the field
+// methods of Writer but does no I/O and cannot fail, so it has no error
+// state. The zero value is ready to use; Reset reuses a buffer.
+//
+//	var e rawpb.Encoder
+//	e.Reset(buf[:0])
+//	e.String(1, "name")
+//	e.Message(2, func(e *rawpb.Encoder) {
+//	    e.Int64(1, ts)
+//	})
+//	buf = e.Encoded()
+type Encoder struct {
+	buf []byte
+
+	// Nested submessages reserve room for the longest length up front. The
+	// room a length leaves unused is a hole, cut out in one pass when the
+	// outermost submessage ends, so no body is copied once per nesting
+	// level. The outermost submessage itself reserves one byte, as
+	// AppendMessage does, so a flat one is never copied at all.
+	holes []hole
+	slack int // total size of holes
+	open  int // number of unfinished submessages
+}
+
+// hole is the unused part of the length room of a finished submessage.
+type hole struct {
+	at, n int
+}
+
+// messageMark is an unfinished submessage, as beginMessage returns it.
+type messageMark struct {
+	tag   int // where its tag starts
+	start int // where its body starts
+	hole  int // index of its hole in holes
+	slack int // slack before the body
+}
+
+// Reset makes e append to buf.
+func (e *Encoder) Reset(buf []byte) {
+	e.buf = buf
+	e.holes = e.holes[:0]
+	e.slack = 0
+	e.open = 0
+}
+
+// Encoded returns the encoded fields. The slice aliases the encoder's
+// buffer and is overwritten after a Reset with the same buffer. Inside a
+// Message callback it is not a valid encoding yet.
+func (e *Encoder) Encoded() []byte {
+	return e.buf
+}
+
+// Len returns the number of bytes encoded so far. Inside a Message
+// callback it includes room reserved for the lengths of submessages.
+func (e *Encoder) Len() int {
+	return len(e.buf)
+}
+
+// Message writes submessage field num; cb encodes its fields into e.
+func (e *Encoder) Message(num int, cb func(e *Encoder)) {
+	m := e.beginMessage(num)
+	cb(e)
+	e.endMessage(m)
+}
+
+// beginMessage appends the tag of submessage field num and the room for
+// its length.
+func (e *Encoder) beginMessage(num int) messageMark {
+	m := messageMark{tag: len(e.buf), hole: len(e.holes), slack: e.slack}
+	if e.open == 0 {
+		e.buf, m.start = beginMessage(e.buf, num)
+	} else {
+		e.buf = AppendTag(e.buf, num, WireLen)
+		e.holes = append(e.holes, hole{at: len(e.buf)})
+		e.buf = append(e.buf, make([]byte, maxVarintBytes)...)
+		m.start = len(e.buf)
+	}
+	e.open++
+	return m
+}
+
+// endMessage writes the length of the body of m, the bytes from m.start
+// less the holes of its submessages. Holes appear in holes in the order of
+// their offsets, since a submessage takes its slot in beginMessage.
+func (e *Encoder) endMessage(m messageMark) {
+	e.open--
+	if e.open == 0 {
+		e.compact()
+		e.buf = endMessage(e.buf, m.start)
+		return
+	}
+	size := len(e.buf) - m.start - (e.slack - m.slack)
+	h := &e.holes[m.hole]
+	n := len(AppendVarint(e.buf[h.at:h.at], uint64(size)))
+	h.at += n
+	h.n = maxVarintBytes - n
+	e.slack += h.n
+}
+
+// cancelMessage drops the unfinished submessage m.
+func (e *Encoder) cancelMessage(m messageMark) {
+	e.buf = e.buf[:m.tag]
+	e.holes = e.holes[:m.hole]
+	e.slack = m.slack
+	e.open--
+}
+
+// compact cuts all holes out of the buffer.
+func (e *Encoder) compact() {
+	if len(e.holes) == 0 {
+		return
+	}
+	to := e.holes[0].at
+	for i, h := range e.holes {
+		end := len(e.buf)
+		if i+1 < len(e.holes) {
+			end = e.holes[i+1].at
+		}
+		to += copy(e.buf[to:], e.buf[h.at+h.n:end])
+	}
+	e.buf = e.buf[:to]
+	e.holes = e.holes[:0]
+	e.slack = 0
+}
+
+// Bytes writes a length-delimited byte slice field
+func (e *Encoder) Bytes(num int, v []byte) {
+	e.buf = AppendTag(e.buf, num, WireLen)
+	e.buf = AppendBytes(e.buf, v)
+}
+
+// String writes a string field
+func (e *Encoder) String(num int, v string) {
+	e.buf = AppendTag(e.buf, num, WireLen)
+	e.buf = AppendString(e.buf, v)
+}
+
+// Raw writes already encoded fields as they are, see Writer.Raw.
+func (e *Encoder) Raw(encoded []byte) {
+	e.buf = append(e.buf, encoded...)
+}
+
+// Fixed64 writes a 64-bit fixed-size field
+func (e *Encoder) Fixed64(num int, v uint64) {
+	e.buf = AppendTag(e.buf, num, WireFixed64)
+	e.buf = AppendFixed64(e.buf, v)
+}
+
+// Fixed32 writes a 32-bit fixed-size field
+func (e *Encoder) Fixed32(num int, v uint32) {
+	e.buf = AppendTag(e.buf, num, WireFixed32)
+	e.buf = AppendFixed32(e.buf, v)
+}
+
+// Uint64 writes an unsigned 64-bit integer field
+func (e *Encoder) Uint64(num int, v uint64) {
+	e.buf = AppendTag(e.buf, num, WireVarint)
+	e.buf = AppendVarint(e.buf, v)
+}
+
+// Int64 writes a signed 64-bit integer field
+func (e *Encoder) Int64(num int, v int64) {
+	e.Uint64(num, uint64(v))
+}
+
+// Double writes a double-precision floating-point field
+func (e *Encoder) Double(num int, v float64) {
+	e.Fixed64(num, math.Float64bits(v))
+}
+
+// Float writes a single-precision floating-point field
+func (e *Encoder) Float(num int, v float32) {
+	e.Fixed32(num, math.Float32bits(v))
+}
+
+// Bool writes a boolean field
+func (e *Encoder) Bool(num int, v bool) {
+	if v {
+		e.Uint64(num, 1)
+	} else {
+		e.Uint64(num, 0)
+	}
+}
+
+// Enum writes a protocol buffer enum field
+func (e *Encoder) Enum(num int, v int32) {
+	e.Int32(num, v)
+}
+
+// Uint32 writes an unsigned 32-bit integer field
+func (e *Encoder) Uint32(num int, v uint32) {
+	e.Uint64(num, uint64(v))
+}
+
+// Int32 writes a signed 32-bit integer field
+func (e *Encoder) Int32(num int, v int32) {
+	e.Uint64(num, uint64(v))
+}
+
+// Sint32 writes a signed 32-bit integer field using zigzag encoding
+func (e *Encoder) Sint32(num int, v int32) {
+	e.Uint32(num, uint32((v<<1)^(v>>31)))
+}
+
+// Sint64 writes a signed 64-bit integer field using zigzag encoding
+func (e *Encoder) Sint64(num int, v int64) {
+	e.Uint64(num, uint64((v<<1)^(v>>63)))
+}
+
+// Sfixed64 writes a signed 64-bit fixed-size field
+func (e *Encoder) Sfixed64(num int, v int64) {
+	e.Fixed64(num, uint64(v))
+}
+
+// Sfixed32 writes a signed 32-bit fixed-size field
+func (e *Encoder) Sfixed32(num int, v int32) {
+	e.Fixed32(num, uint32(v))
+}
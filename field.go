@@ -1,6 +1,7 @@
 package rawpb
 
 import (
+	"io"
 	"math"
 	"unsafe"
 )
@@ -18,6 +19,34 @@ func Bytes(num int, f func([]byte) error) Option {
 	}
 }
 
+// BytesReader registers a callback for length-delimited fields that
+// streams the payload instead of holding it in memory, for blobs too large
+// to allocate. r yields exactly n bytes and is only valid during the call.
+// Whatever the callback leaves unread is skipped after it returns nil;
+// after an error the parse stops where the callback left the stream.
+//
+// With Read, MaxSize still applies: a payload that would cross it fails
+// before the callback runs, and if the stream ends early r returns
+// io.ErrUnexpectedEOF. With Parse, r reads from the input slice.
+func BytesReader(num int, f func(r io.Reader, n uint64) error) Option {
+	return func(p *RawPB) {
+		p.schema.setBytesReader(num, f)
+	}
+}
+
+// Raw registers a callback that receives the complete encoding of field
+// num, tag included, whatever its wire type. Passing it to Writer.Raw
+// reproduces the field byte for byte, which is what proxies and filters
+// need for fields they do not interpret.
+//
+// With Parse the slice aliases the input, with the same lifetime rules as
+// Bytes. Read copies the field into memory from the allocator.
+func Raw(num int, f func(raw []byte) error) Option {
+	return func(p *RawPB) {
+		p.schema.setRaw(num, f)
+	}
+}
+
 // Varint registers a callback for varint-encoded fields
 func Varint(num int, f func(uint64) error) Option {
 	return func(p *RawPB) {
@@ -93,12 +122,12 @@ func CopyString(num int, f func(string) error) Option {
 
 // Int64 registers a callback for signed 64-bit integers using varint encoding
 func Int64(num int, f func(int64) error) Option {
-	return Varint(num, func(u uint64) error {
+	return signed(num, signedInt64, Varint(num, func(u uint64) error {
 		if f == nil {
 			return nil
 		}
 		return f(int64(u))
-	})
+	}))
 }
 
 // Double registers a callback for double-precision floating point numbers using fixed64 encoding
@@ -148,17 +177,17 @@ func Uint32(num int, f func(uint32) error) Option {
 
 // Int32 registers a callback for signed 32-bit integers using varint encoding
 func Int32(num int, f func(int32) error) Option {
-	return Uint32(num, func(u uint32) error {
+	return signed(num, signedInt32, Uint32(num, func(u uint32) error {
 		if f == nil {
 			return nil
 		}
 		return f(int32(u))
-	})
+	}))
 }
 
 // Sint32 registers a callback for zigzag-encoded signed 32-bit integers
 func Sint32(num int, f func(int32) error) Option {
-	return Uint32(num, func(u uint32) error {
+	return signed(num, signedSint32, Uint32(num, func(u uint32) error {
 		if f == nil {
 			return nil
 		}
@@ -166,12 +195,12 @@ func Sint32(num int, f func(int32) error) Option {
 			return f(int32(u / 2))
 		}
 		return f(-int32(u/2) - 1)
-	})
+	}))
 }
 
 // Sint64 registers a callback for zigzag-encoded signed 64-bit integers
 func Sint64(num int, f func(int64) error) Option {
-	return Uint64(num, func(u uint64) error {
+	return signed(num, signedSint64, Uint64(num, func(u uint64) error {
 		if f == nil {
 			return nil
 		}
@@ -179,25 +208,45 @@ func Sint64(num int, f func(int64) error) Option {
 			return f(int64(u / 2))
 		}
 		return f(-int64(u/2) - 1)
-	})
+	}))
 }
 
 // Sfixed64 registers a callback for signed 64-bit integers using fixed64 encoding
 func Sfixed64(num int, f func(int64) error) Option {
-	return Fixed64(num, func(u uint64) error {
+	return signed(num, signedInt64, Fixed64(num, func(u uint64) error {
 		if f == nil {
 			return nil
 		}
 		return f(int64(u))
-	})
+	}))
 }
 
 // Sfixed32 registers a callback for signed 32-bit integers using fixed32 encoding
 func Sfixed32(num int, f func(int32) error) Option {
-	return Fixed32(num, func(u uint32) error {
+	return signed(num, signedInt32, Fixed32(num, func(u uint32) error {
 		if f == nil {
 			return nil
 		}
 		return f(int32(u))
-	})
+	}))
+}
+
+// signed registers o and marks field num as a signed integer whose wire
+// values conv converts.
+func signed(num int, conv func(uint64) int64, o Option) Option {
+	return func(p *RawPB) {
+		o(p)
+		p.schema.setSigned(num, conv)
+	}
 }
+
+func signedInt64(v uint64) int64 { return int64(v) }
+
+func signedInt32(v uint64) int64 { return int64(int32(v)) }
+
+func signedSint32(v uint64) int64 {
+	u := uint32(v)
+	return int64(int32(u>>1) ^ -int32(u&1))
+}
+
+func signedSint64(v uint64) int64 { return int64(v>>1) ^ -int64(v&1) }
@@ -0,0 +1,238 @@
+// Package grpcframe reads and writes gRPC length-prefixed messages without
+// grpc-go. Every frame is a 1-byte compressed flag, a 4-byte big-endian
+// payload length and the payload itself. Compressed frames use gzip, the
+// only compressor every gRPC implementation ships.
+//
+//	fr := grpcframe.NewReader(body)
+//	for {
+//	    msg, err := fr.Next()
+//	    if err == io.EOF { break }
+//	    if err != nil { return err }
+//	    if err := schema.Parse(msg); err != nil { return err }
+//	}
+package grpcframe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/lomik/rawpb"
+)
+
+// HeaderSize is the size of the frame prefix.
+const HeaderSize = 5
+
+// DefaultMaxSize mirrors grpc-go's default receive limit.
+const DefaultMaxSize = 4 << 20
+
+const (
+	flagUncompressed = 0
+	flagCompressed   = 1
+)
+
+// Reader reads gRPC frames from a stream. The payload buffer, the
+// decompression buffer and the gzip reader are reused across frames.
+type Reader struct {
+	r          io.Reader
+	hdr        [HeaderSize]byte
+	buf        []byte
+	dec        bytes.Buffer
+	gz         *gzip.Reader
+	maxSize    uint32
+	compressed bool
+}
+
+// NewReader returns a Reader over r with DefaultMaxSize.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:       r,
+		maxSize: DefaultMaxSize,
+	}
+}
+
+// SetMaxSize limits the payload of a frame, both as sent and after
+// decompression. Zero means unlimited. Larger frames fail with
+// rawpb.ErrorTooLarge.
+func (r *Reader) SetMaxSize(size uint32) {
+	r.maxSize = size
+}
+
+// Compressed reports whether the frame returned by the last Next call was
+// compressed on the wire.
+func (r *Reader) Compressed() bool {
+	return r.compressed
+}
+
+// Next reads the next frame and returns its (decompressed) payload. The
+// slice is only valid until the next call. At a clean end of stream Next
+// returns io.EOF; a stream that ends inside a frame yields
+// rawpb.ErrorTruncated.
+func (r *Reader) Next() ([]byte, error) {
+	if _, err := io.ReadFull(r.r, r.hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("%w: %w", rawpb.ErrorTruncated, err)
+	}
+
+	flag := r.hdr[0]
+	if flag != flagUncompressed && flag != flagCompressed {
+		return nil, fmt.Errorf("%w: unknown compressed flag %d", rawpb.ErrorInvalidMessage, flag)
+	}
+	l := binary.BigEndian.Uint32(r.hdr[1:])
+	if r.maxSize > 0 && l > r.maxSize {
+		return nil, rawpb.ErrorTooLarge
+	}
+
+	var err error
+	if r.buf, err = readPayload(r.r, r.buf, l); err != nil {
+		return nil, fmt.Errorf("%w: %w", rawpb.ErrorTruncated, err)
+	}
+
+	r.compressed = flag == flagCompressed
+	if !r.compressed {
+		return r.buf, nil
+	}
+	return r.decompress()
+}
+
+// readPayload reads an l-byte payload into buf, reusing its capacity. Past
+// that capacity buf only grows as data arrives: with SetMaxSize(0) the
+// header alone may claim up to 4 GiB, and that must not be allocated
+// before the stream proves it carries it.
+func readPayload(r io.Reader, buf []byte, l uint32) ([]byte, error) {
+	buf = buf[:0]
+	for uint32(len(buf)) < l {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := io.ReadFull(r, buf[len(buf):min(uint32(cap(buf)), l)])
+		buf = buf[:len(buf)+n]
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+func (r *Reader) decompress() ([]byte, error) {
+	src := bytes.NewReader(r.buf)
+	if r.gz == nil {
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", rawpb.ErrorInvalidMessage, err)
+		}
+		r.gz = gz
+	} else if err := r.gz.Reset(src); err != nil {
+		return nil, fmt.Errorf("%w: %w", rawpb.ErrorInvalidMessage, err)
+	}
+
+	var in io.Reader = r.gz
+	if r.maxSize > 0 {
+		in = io.LimitReader(r.gz, int64(r.maxSize)+1)
+	}
+	r.dec.Reset()
+	n, err := r.dec.ReadFrom(in)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", rawpb.ErrorInvalidMessage, err)
+	}
+	if r.maxSize > 0 && n > int64(r.maxSize) {
+		return nil, rawpb.ErrorTooLarge
+	}
+	return r.dec.Bytes(), nil
+}
+
+// Parse reads the next frame and parses it with pb.
+func (r *Reader) Parse(pb *rawpb.RawPB) error {
+	msg, err := r.Next()
+	if err != nil {
+		return err
+	}
+	return pb.Parse(msg)
+}
+
+// Decoder reads the next frame and binds d to it.
+func (r *Reader) Decoder(d *rawpb.Decoder) error {
+	msg, err := r.Next()
+	if err != nil {
+		return err
+	}
+	d.Reset(msg)
+	return nil
+}
+
+// Writer writes gRPC frames. Encoding and compression buffers are reused
+// across frames.
+type Writer struct {
+	w        io.Writer
+	hdr      [HeaderSize]byte
+	buf      bytes.Buffer
+	pw       *rawpb.Writer
+	zbuf     bytes.Buffer
+	gz       *gzip.Writer
+	compress bool
+}
+
+// NewWriter returns a Writer that writes uncompressed frames to w.
+func NewWriter(w io.Writer) *Writer {
+	fw := &Writer{w: w}
+	fw.pw = rawpb.NewWriter(&fw.buf)
+	return fw
+}
+
+// SetCompression enables or disables gzip compression of subsequent
+// frames. The peer must have negotiated grpc-encoding: gzip.
+func (w *Writer) SetCompression(gzip bool) {
+	w.compress = gzip
+}
+
+// WriteMessage encodes a message with cb and writes it as one frame.
+func (w *Writer) WriteMessage(cb func(w *rawpb.Writer) error) error {
+	// start every frame from a clean encoder: a failed message leaves its
+	// sticky error and its unflushed fields in pw
+	w.buf.Reset()
+	w.pw.Reset(&w.buf)
+	if err := cb(w.pw); err != nil {
+		return err
+	}
+	if err := w.pw.Flush(); err != nil {
+		return err
+	}
+	return w.WriteFrame(w.buf.Bytes())
+}
+
+// WriteFrame writes an already encoded message as one frame, compressing
+// it if compression is enabled.
+func (w *Writer) WriteFrame(msg []byte) error {
+	flag := byte(flagUncompressed)
+	if w.compress {
+		w.zbuf.Reset()
+		if w.gz == nil {
+			w.gz = gzip.NewWriter(&w.zbuf)
+		} else {
+			w.gz.Reset(&w.zbuf)
+		}
+		if _, err := w.gz.Write(msg); err != nil {
+			return err
+		}
+		if err := w.gz.Close(); err != nil {
+			return err
+		}
+		msg = w.zbuf.Bytes()
+		flag = flagCompressed
+	}
+
+	if uint64(len(msg)) > 0xffffffff {
+		return rawpb.ErrorTooLarge
+	}
+	w.hdr[0] = flag
+	binary.BigEndian.PutUint32(w.hdr[1:], uint32(len(msg)))
+	if _, err := w.w.Write(w.hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(msg)
+	return err
+}
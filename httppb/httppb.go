@@ -0,0 +1,286 @@
+// Package httppb turns a rawpb schema or decode function into an
+// http.Handler that takes care of the boilerplate around protobuf request
+// bodies: Content-Type and Content-Encoding checks, body size caps,
+// decompression with a decompressed size cap, pooled buffers and
+// allocators, and mapping parse errors onto HTTP status codes.
+//
+//	http.Handle("/api/v1/write", httppb.New(func(r *http.Request, body []byte, alloc *rawpb.LinearAllocator) error {
+//	    var d rawpb.Decoder
+//	    d.Reset(body)
+//	    ...
+//	    return d.Err()
+//	}))
+package httppb
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/lomik/rawpb"
+	"github.com/lomik/rawpb/snappy"
+)
+
+// DefaultMaxSize is the default cap on a decompressed request body.
+const DefaultMaxSize = 32 << 20
+
+var (
+	// ErrUnsupportedMediaType is returned for a Content-Type or
+	// Content-Encoding the handler is not configured to accept.
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	// ErrBadRequest can be wrapped by ParseFunc errors that should be
+	// reported as 400 Bad Request.
+	ErrBadRequest = errors.New("bad request")
+)
+
+// ParseFunc decodes one request body. body is the decompressed payload and
+// alloc a reset LinearAllocator; both come from pools and are only valid
+// until ParseFunc returns.
+type ParseFunc func(r *http.Request, body []byte, alloc *rawpb.LinearAllocator) error
+
+// Handler is an http.Handler that reads, checks and decompresses protobuf
+// request bodies and hands them to a ParseFunc.
+type Handler struct {
+	parse        ParseFunc
+	contentTypes []string
+	encodings    []string
+	maxBodySize  int64
+	maxSize      int64
+	status       int
+	errorHandler func(w http.ResponseWriter, r *http.Request, err error, status int)
+
+	bufPool   sync.Pool // *bytes.Buffer, raw body
+	decPool   sync.Pool // *[]byte, decompressed body
+	allocPool sync.Pool // *rawpb.LinearAllocator
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// ContentTypes sets the accepted media types (parameters are ignored when
+// matching). An empty Content-Type header is always accepted. The default
+// is application/x-protobuf, application/protobuf and
+// application/vnd.google.protobuf.
+func ContentTypes(types ...string) Option {
+	return func(h *Handler) {
+		h.contentTypes = types
+	}
+}
+
+// Encodings sets the accepted Content-Encoding values. Supported values
+// are "identity", "snappy" (block format) and "gzip"; the default accepts
+// all of them. A missing header means identity.
+func Encodings(encodings ...string) Option {
+	return func(h *Handler) {
+		h.encodings = encodings
+	}
+}
+
+// MaxBodySize caps the request body as sent on the wire. It defaults to
+// MaxSize.
+func MaxBodySize(size int64) Option {
+	return func(h *Handler) {
+		h.maxBodySize = size
+	}
+}
+
+// MaxSize caps the decompressed body (default DefaultMaxSize). Larger
+// bodies are rejected with 413 before ParseFunc runs.
+func MaxSize(size int64) Option {
+	return func(h *Handler) {
+		h.maxSize = size
+	}
+}
+
+// Status sets the status code written after ParseFunc succeeds (default
+// 204 No Content).
+func Status(code int) Option {
+	return func(h *Handler) {
+		h.status = code
+	}
+}
+
+// ErrorHandler replaces the default error response, which is the error
+// text with the status from StatusCode.
+func ErrorHandler(f func(w http.ResponseWriter, r *http.Request, err error, status int)) Option {
+	return func(h *Handler) {
+		h.errorHandler = f
+	}
+}
+
+// New returns a Handler that calls parse for every request body.
+func New(parse ParseFunc, opts ...Option) *Handler {
+	h := &Handler{
+		parse:        parse,
+		contentTypes: []string{"application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf"},
+		encodings:    []string{"identity", "snappy", "gzip"},
+		maxSize:      DefaultMaxSize,
+		status:       http.StatusNoContent,
+		errorHandler: func(w http.ResponseWriter, r *http.Request, err error, status int) {
+			http.Error(w, err.Error(), status)
+		},
+	}
+	for _, o := range opts {
+		o(h)
+	}
+	if h.maxBodySize == 0 {
+		h.maxBodySize = h.maxSize
+	}
+	return h
+}
+
+// Schema returns a Handler that parses every body with the Parse of a
+// schema built by newSchema.
+//
+// Schemas usually keep per-message state in their callback closures, so a
+// schema is never shared between concurrent requests: each request takes
+// one from a pool and builds a new one when the pool is empty. newSchema
+// must therefore return an independent schema on every call.
+func Schema(newSchema func() *rawpb.RawPB, opts ...Option) *Handler {
+	pool := sync.Pool{New: func() any { return newSchema() }}
+	return New(func(r *http.Request, body []byte, alloc *rawpb.LinearAllocator) error {
+		pb := pool.Get().(*rawpb.RawPB)
+		defer pool.Put(pb)
+		return pb.Parse(body)
+	}, opts...)
+}
+
+// StatusCode maps an error returned while handling a body to an HTTP
+// status: 413 for size limits, 415 for unsupported media types, 400 for
+// malformed or undecodable input and 500 for anything else.
+func StatusCode(err error) int {
+	var maxBytes *http.MaxBytesError
+	var corrupt flate.CorruptInputError
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, rawpb.ErrorTooLarge), errors.Is(err, snappy.ErrTooLarge), errors.As(err, &maxBytes):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedMediaType), errors.Is(err, snappy.ErrUnsupported):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, rawpb.ErrorTruncated), errors.Is(err, rawpb.ErrorInvalidMessage),
+		errors.Is(err, rawpb.ErrorWrongWireType), errors.Is(err, snappy.ErrCorrupt),
+		errors.Is(err, gzip.ErrHeader), errors.Is(err, gzip.ErrChecksum),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &corrupt),
+		errors.Is(err, ErrBadRequest):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.serve(w, r); err != nil {
+		h.errorHandler(w, r, err, StatusCode(err))
+		return
+	}
+	w.WriteHeader(h.status)
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	if err := h.checkContentType(r.Header.Get("Content-Type")); err != nil {
+		return err
+	}
+	encoding := r.Header.Get("Content-Encoding")
+	if encoding == "" {
+		encoding = "identity"
+	}
+	if !slices.Contains(h.encodings, encoding) {
+		return ErrUnsupportedMediaType
+	}
+	if r.ContentLength > h.maxBodySize {
+		return rawpb.ErrorTooLarge
+	}
+
+	buf, _ := h.bufPool.Get().(*bytes.Buffer)
+	if buf == nil {
+		buf = new(bytes.Buffer)
+	}
+	defer func() {
+		buf.Reset()
+		h.bufPool.Put(buf)
+	}()
+
+	if _, err := buf.ReadFrom(http.MaxBytesReader(w, r.Body, h.maxBodySize)); err != nil {
+		return err
+	}
+
+	dec, _ := h.decPool.Get().(*[]byte)
+	if dec == nil {
+		dec = new([]byte)
+	}
+	defer h.decPool.Put(dec)
+
+	body, err := h.decompress(encoding, buf.Bytes(), dec)
+	if err != nil {
+		return err
+	}
+
+	alloc, _ := h.allocPool.Get().(*rawpb.LinearAllocator)
+	if alloc == nil {
+		alloc = rawpb.NewLinearAllocator()
+	}
+	defer func() {
+		alloc.Reset()
+		h.allocPool.Put(alloc)
+	}()
+
+	return h.parse(r, body, alloc)
+}
+
+func (h *Handler) checkContentType(ct string) error {
+	if ct == "" {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil || !slices.Contains(h.contentTypes, mt) {
+		return ErrUnsupportedMediaType
+	}
+	return nil
+}
+
+// decompress returns the decoded body, reusing *dec as the output buffer
+// for compressed encodings.
+func (h *Handler) decompress(encoding string, raw []byte, dec *[]byte) ([]byte, error) {
+	switch encoding {
+	case "snappy":
+		n, err := snappy.DecodedLen(raw)
+		if err != nil {
+			return nil, err
+		}
+		if int64(n) > h.maxSize {
+			return nil, rawpb.ErrorTooLarge
+		}
+		out, err := snappy.Decode((*dec)[:0], raw)
+		if err != nil {
+			return nil, err
+		}
+		*dec = out
+		return out, nil
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		out := bytes.NewBuffer((*dec)[:0])
+		n, err := out.ReadFrom(io.LimitReader(zr, h.maxSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if n > h.maxSize {
+			return nil, rawpb.ErrorTooLarge
+		}
+		*dec = out.Bytes()
+		return *dec, nil
+	}
+	if int64(len(raw)) > h.maxSize {
+		return nil, rawpb.ErrorTooLarge
+	}
+	return raw, nil
+}
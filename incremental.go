@@ -0,0 +1,303 @@
+package rawpb
+
+import "math"
+
+// incFrame is a message the incremental parser descended from.
+type incFrame struct {
+	pb  *RawPB
+	end uint64
+	num int // field number of the submessage in pb
+}
+
+// Incremental is a push-based parser for input that arrives in chunks,
+// e.g. from a non-blocking socket. Feed hands it the next chunk: every
+// field completed by the chunk is dispatched to the schema callbacks right
+// away, and a partial tag, value or payload at the end is kept for the next
+// Feed. Close reports whether the input was a complete message.
+//
+// Callbacks, values and errors are the same as with Parse on the
+// concatenated chunks. Submessages are entered as soon as their tag and
+// length arrive, so Begin runs early and End as soon as the last byte is
+// fed. Bytes, string, packed and Raw values are buffered until complete,
+// because their callbacks take the whole value; payloads nobody reads are
+// skipped without buffering. Slices passed to callbacks alias the chunk or
+// the internal buffer and are only valid during the call.
+//
+// Errors are sticky: after the first, Feed and Close keep returning it.
+type Incremental struct {
+	root    *RawPB
+	pb      *RawPB // the message being parsed
+	end     uint64 // input offset where it ends
+	stack   []incFrame
+	pos     uint64 // input offset of pending[0]
+	pending []byte // start of an incomplete field
+	skip    uint64 // payload bytes still to drop
+	skipNum int    // field number of the skipped payload
+	started bool
+	closed  bool
+	err     error
+	rb      readerBody
+}
+
+// NewIncremental returns an incremental parser for pb.
+func (pb *RawPB) NewIncremental() *Incremental {
+	p := &Incremental{root: pb}
+	p.Reset()
+	return p
+}
+
+// Reset prepares p for a new message, keeping its buffers.
+func (p *Incremental) Reset() {
+	*p = Incremental{
+		root:    p.root,
+		pb:      p.root,
+		end:     math.MaxUint64,
+		stack:   p.stack[:0],
+		pending: p.pending[:0],
+	}
+}
+
+// Feed parses the next chunk of input. It returns the first error of the
+// message, now or from an earlier Feed. chunk is not retained.
+func (p *Incremental) Feed(chunk []byte) error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.closed {
+		p.err = ErrorInvalidMessage
+		return p.err
+	}
+	if m := p.root.maxSize; m > 0 && p.pos+uint64(len(p.pending)+len(chunk)) > m {
+		p.err = ErrorTooLarge
+		return p.err
+	}
+	if !p.begin() {
+		return p.err
+	}
+
+	data := chunk
+	if len(p.pending) > 0 {
+		p.pending = append(p.pending, chunk...)
+		data = p.pending
+	}
+	n, err := p.consume(data)
+	if err != nil {
+		p.err = p.unwind(err)
+		return p.err
+	}
+	// keep the incomplete field; when nothing was used it is in pending
+	// already, and copying it again would make a long payload fed in small
+	// chunks cost quadratic time. The copy within pending is safe: n only
+	// moves forward
+	if n > 0 || len(p.pending) == 0 {
+		p.pending = append(p.pending[:0], data[n:]...)
+	}
+	return nil
+}
+
+// Close ends the input. It returns ErrorTruncated if the input stopped
+// inside a field or submessage, and otherwise runs the End callback of the
+// top-level message.
+func (p *Incremental) Close() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.closed {
+		return nil
+	}
+	if !p.begin() {
+		return p.err
+	}
+	p.closed = true
+	// report truncation the way Parse does
+	var err error
+	switch {
+	case len(p.stack) > 0:
+		// Parse finds the outermost open submessage too short
+		err = p.stack[0].pb.wrapError(p.stack[0].num, ErrorTruncated)
+	case len(p.pending) > 0:
+		if _, err = p.field(p.pending, true); err == nil {
+			err = ErrorTruncated
+		}
+	case p.skip > 0:
+		err = p.pb.wrapError(p.skipNum, ErrorTruncated)
+	}
+	if err != nil {
+		p.err = err
+		return p.err
+	}
+	if p.pb.endFunc != nil {
+		p.err = p.pb.endFunc()
+	}
+	return p.err
+}
+
+// begin runs the Begin callback of the top-level message once.
+func (p *Incremental) begin() bool {
+	if p.started {
+		return true
+	}
+	p.started = true
+	if p.pb.beginFunc != nil {
+		p.err = p.pb.beginFunc()
+	}
+	return p.err == nil
+}
+
+// consume dispatches the complete fields at the start of data, whose
+// first byte is at input offset p.pos, and returns how many bytes it used.
+func (p *Incremental) consume(data []byte) (int, error) {
+	off := 0
+	for {
+		// leave the submessages that ended
+		for len(p.stack) > 0 && p.pos == p.end {
+			if p.pb.endFunc != nil {
+				if err := p.pb.endFunc(); err != nil {
+					return off, err
+				}
+			}
+			top := p.stack[len(p.stack)-1]
+			p.stack = p.stack[:len(p.stack)-1]
+			p.pb, p.end = top.pb, top.end
+		}
+		if off == len(data) {
+			return off, nil
+		}
+
+		if p.skip > 0 {
+			n := min(p.skip, uint64(len(data)-off))
+			p.skip -= n
+			p.pos += n
+			off += int(n)
+			continue
+		}
+
+		// b is the rest of the current message we have; final when the
+		// message ends inside it, so nothing more can complete a value
+		b := data[off:]
+		final := false
+		if rest := p.end - p.pos; rest <= uint64(len(b)) {
+			b = b[:rest]
+			final = true
+		}
+		n, err := p.field(b, final)
+		if err != nil || n == 0 {
+			return off, err
+		}
+		p.pos += uint64(n)
+		off += n
+	}
+}
+
+// field handles the field at the start of b. It returns 0 if b holds only
+// part of it and more input may follow.
+func (p *Incremental) field(b []byte, final bool) (int, error) {
+	tag, n, err := decodeVarint(b)
+	if err != nil {
+		if err == ErrorTruncated && !final {
+			return 0, nil
+		}
+		return 0, err
+	}
+	num, wt, err := parseTag(tag)
+	if err != nil {
+		return 0, p.pb.wrapError(num, err)
+	}
+	wait := func(err error) (int, error) {
+		if err == ErrorTruncated && !final {
+			return 0, nil
+		}
+		return 0, p.pb.wrapError(num, err)
+	}
+	c := p.pb.schema.get(num)
+
+	size := n
+	switch wt {
+	case WireVarint:
+		_, vn, err := decodeVarint(b[n:])
+		if err != nil {
+			return wait(err)
+		}
+		size += vn
+	case WireFixed64:
+		size += 8
+	case WireFixed32:
+		size += 4
+	case WireLen:
+		l, ln, err := decodeVarint(b[n:])
+		if err != nil {
+			return wait(err)
+		}
+		n += ln
+		if l > p.end-p.pos-uint64(n) {
+			// longer than the rest of the message, whatever comes next
+			return 0, p.pb.wrapError(num, ErrorTruncated)
+		}
+		if c.tp != callbackTypeRaw {
+			if done, err := p.enter(c, num, uint64(n), l); done || err != nil {
+				return n, err
+			}
+		}
+		if l > uint64(math.MaxInt-n) {
+			return 0, p.pb.wrapError(num, ErrorInvalidMessage)
+		}
+		size = n + int(l)
+	default:
+		return 0, ErrorWrongWireType
+	}
+	if size > len(b) {
+		return wait(ErrorTruncated)
+	}
+
+	// the field is complete: run it through the Parse engine
+	p.rb = newReaderBody(b[:size])
+	p.rb.tag()
+	if c.tp == callbackTypeRaw {
+		err = parseRaw(&c, &p.rb, wt)
+	} else {
+		err = parseField(p.pb, &p.rb, &c, num, wt)
+	}
+	p.rb = readerBody{}
+	if err != nil {
+		return 0, p.pb.wrapError(num, err)
+	}
+	return size, nil
+}
+
+// enter handles a length-delimited payload of l bytes, after a header of
+// hdr bytes, that does not need buffering: a submessage is entered, an
+// unread payload is skipped. done is false for payloads that go to a
+// callback in one piece.
+func (p *Incremental) enter(c callback, num int, hdr, l uint64) (done bool, err error) {
+	switch {
+	case c.tp == callbackTypeMessage && c.message != nil:
+		if m := c.message.maxSize; m > 0 && l > m {
+			return true, p.pb.wrapError(num, ErrorTooLarge)
+		}
+		p.stack = append(p.stack, incFrame{pb: p.pb, end: p.end, num: num})
+		p.pb = c.message
+		p.end = p.pos + hdr + l
+		if p.pb.beginFunc != nil {
+			if err := p.pb.beginFunc(); err != nil {
+				return true, err
+			}
+		}
+	case c.tp == callbackTypeMessage,
+		c.tp == callbackTypeBytes && c.funcBytes == nil && c.funcReader == nil,
+		c.tp == callbackTypeNone && p.pb.schema.unknown.bytes == nil:
+		p.skip = l
+		p.skipNum = num
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// unwind wraps err with the field of every enclosing message, the way
+// nested Parse calls do on the way out.
+func (p *Incremental) unwind(err error) error {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		err = p.stack[i].pb.wrapError(p.stack[i].num, err)
+	}
+	return err
+}
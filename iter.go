@@ -0,0 +1,245 @@
+package rawpb
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"iter"
+	"math"
+)
+
+// Field is the element of Decoder.Fields: a view of the decoder positioned
+// on one field, with the decoder's accessors but not Next or Reset. It is
+// only valid during its iteration. Accessors act on the decoder itself, so
+// an accessor error is sticky in d.Err() and ends the loop, and a scalar
+// accessor on a packed field enters packed continuation as Decoder does.
+type Field struct {
+	d *Decoder
+}
+
+// Fields returns an iterator over the remaining fields of d. Each field is
+// yielded with a nil error. If decoding or an accessor fails, the error is
+// yielded once and iteration ends; errors stay sticky, so d.Err() still
+// reports it afterwards.
+//
+//	for f, err := range d.Fields() {
+//	    if err != nil { return err }
+//	    switch f.Num() { ... }
+//	}
+func (d *Decoder) Fields() iter.Seq2[Field, error] {
+	return func(yield func(Field, error) bool) {
+		for d.Next() {
+			if !yield(Field{d}, nil) {
+				return
+			}
+		}
+		if err := d.Err(); err != nil {
+			yield(Field{d}, err)
+		}
+	}
+}
+
+// Uint64s returns an iterator over the values of repeated varint field num
+// in the rest of the message, packed or not. Other fields are skipped. The
+// iterator yields values only; check d.Err() after the loop.
+func (d *Decoder) Uint64s(num int) iter.Seq[uint64] {
+	return decoderValues(d, num, (*Decoder).Uint64)
+}
+
+// Int64s is Uint64s for int64 fields.
+func (d *Decoder) Int64s(num int) iter.Seq[int64] {
+	return decoderValues(d, num, (*Decoder).Int64)
+}
+
+// Doubles is Uint64s for double fields.
+func (d *Decoder) Doubles(num int) iter.Seq[float64] {
+	return decoderValues(d, num, (*Decoder).Double)
+}
+
+// Floats is Uint64s for float fields.
+func (d *Decoder) Floats(num int) iter.Seq[float32] {
+	return decoderValues(d, num, (*Decoder).Float)
+}
+
+// UnsafeStrings returns an iterator over the values of repeated string
+// field num, with the lifetime rules of UnsafeString.
+func (d *Decoder) UnsafeStrings(num int) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for d.Find(num) {
+			v := d.UnsafeString()
+			if d.err != nil || !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+func decoderValues[T any](d *Decoder, num int, get func(*Decoder) T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for d.Find(num) {
+			if d.wt == WireLen && len(d.slice) == 0 {
+				continue // empty packed field
+			}
+			v := get(d)
+			if d.err != nil || !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Messages returns an iterator over a stream of varint length-prefixed
+// messages, the framing of protodelim and of Prometheus' delimited
+// exposition format. The payload slice is reused and only valid until the
+// next iteration. The buffer grows with the data actually read, so a bogus
+// length prefix cannot force a large allocation up front.
+//
+// A clean end of stream ends iteration. A stream that ends inside a
+// message yields ErrorTruncated once; read errors are wrapped in
+// ErrorTruncated as in Read. A length prefix over maxSize yields
+// ErrorTooLarge, like the MaxSize option of Read; zero means unlimited.
+//
+// stream is wrapped in a bufio.Reader unless it is an io.ByteReader.
+func Messages(stream io.Reader, maxSize uint64) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		br, ok := stream.(interface {
+			io.Reader
+			io.ByteReader
+		})
+		if !ok {
+			br = bufio.NewReader(stream)
+		}
+
+		var buf bytes.Buffer
+		for {
+			n, err := readDelimitedLength(br)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if maxSize > 0 && n > maxSize {
+				yield(nil, ErrorTooLarge)
+				return
+			}
+
+			buf.Reset()
+			if _, err := io.CopyN(&buf, br, int64(n)); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				yield(nil, truncated(err))
+				return
+			}
+			if !yield(buf.Bytes(), nil) {
+				return
+			}
+		}
+	}
+}
+
+// readDelimitedLength reads a length prefix. It returns io.EOF only when
+// the stream ends before the first byte.
+func readDelimitedLength(r io.ByteReader) (uint64, error) {
+	var v uint64
+	for i := 0; i < maxVarintBytes; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && i == 0 {
+				return 0, io.EOF
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			return 0, truncated(err)
+		}
+		v |= uint64(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			if v > math.MaxInt64 {
+				return 0, ErrorInvalidMessage
+			}
+			return v, nil
+		}
+	}
+	return 0, ErrorInvalidMessage
+}
+
+// Num returns the field number.
+func (f *Field) Num() int { return f.d.Num() }
+
+// WireType returns the wire type, see Decoder.WireType.
+func (f *Field) WireType() int { return f.d.WireType() }
+
+// Uint64 returns the value as uint64, see Decoder.Uint64.
+func (f *Field) Uint64() uint64 { return f.d.Uint64() }
+
+// Int64 returns the value as int64.
+func (f *Field) Int64() int64 { return f.d.Int64() }
+
+// Uint32 returns the value as uint32.
+func (f *Field) Uint32() uint32 { return f.d.Uint32() }
+
+// Int32 returns the value as int32.
+func (f *Field) Int32() int32 { return f.d.Int32() }
+
+// Bool returns the value as bool.
+func (f *Field) Bool() bool { return f.d.Bool() }
+
+// Sint32 returns the zigzag-decoded value.
+func (f *Field) Sint32() int32 { return f.d.Sint32() }
+
+// Sint64 returns the zigzag-decoded value.
+func (f *Field) Sint64() int64 { return f.d.Sint64() }
+
+// Fixed64 returns the 64-bit fixed value.
+func (f *Field) Fixed64() uint64 { return f.d.Fixed64() }
+
+// Sfixed64 returns the 64-bit fixed value as int64.
+func (f *Field) Sfixed64() int64 { return f.d.Sfixed64() }
+
+// Double returns the 64-bit fixed value as float64.
+func (f *Field) Double() float64 { return f.d.Double() }
+
+// Fixed32 returns the 32-bit fixed value.
+func (f *Field) Fixed32() uint32 { return f.d.Fixed32() }
+
+// Sfixed32 returns the 32-bit fixed value as int32.
+func (f *Field) Sfixed32() int32 { return f.d.Sfixed32() }
+
+// Float returns the 32-bit fixed value as float32.
+func (f *Field) Float() float32 { return f.d.Float() }
+
+// Bytes returns the length-delimited payload, see Decoder.Bytes.
+func (f *Field) Bytes() []byte { return f.d.Bytes() }
+
+// BytesCopy returns a copy of the length-delimited payload.
+func (f *Field) BytesCopy() []byte { return f.d.BytesCopy() }
+
+// UnsafeString returns the payload as a string aliasing the input.
+func (f *Field) UnsafeString() string { return f.d.UnsafeString() }
+
+// CopyString returns the payload as a copied string.
+func (f *Field) CopyString() string { return f.d.CopyString() }
+
+// Submessage returns a Decoder over the payload, see Decoder.Submessage.
+func (f *Field) Submessage() Decoder { return f.d.Submessage() }
+
+// RawField returns the encoded field, see Decoder.RawField.
+func (f *Field) RawField() []byte { return f.d.RawField() }
+
+// AppendUint64s appends the values of the field, see Decoder.AppendUint64s.
+func (f *Field) AppendUint64s(dst []uint64) []uint64 { return f.d.AppendUint64s(dst) }
+
+// AppendInt64s appends the values of the field, see Decoder.AppendInt64s.
+func (f *Field) AppendInt64s(dst []int64) []int64 { return f.d.AppendInt64s(dst) }
+
+// AppendDoubles appends the values of the field, see Decoder.AppendDoubles.
+func (f *Field) AppendDoubles(dst []float64) []float64 { return f.d.AppendDoubles(dst) }
+
+// AppendFloats appends the values of the field, see Decoder.AppendFloats.
+func (f *Field) AppendFloats(dst []float32) []float32 { return f.d.AppendFloats(dst) }
+
+// Err returns the decoder's error, see Decoder.Err.
+func (f *Field) Err() error { return f.d.Err() }
@@ -0,0 +1,135 @@
+package rawpb
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// Map fields are written as repeated submessages with the key in field 1
+// and the value in field 2. Both are always written, even with
+// OmitDefaults, as other encoders do. Entries follow Go map order unless
+// the Writer was created with Deterministic.
+
+// MapKey lists the Go types of map keys, with the protobuf type they are
+// written as: string, int32, int64, uint32, uint64 and bool.
+type MapKey interface {
+	string | int32 | int64 | uint32 | uint64 | bool
+}
+
+// MapValue lists the Go types of scalar map values, with the protobuf type
+// they are written as; []byte is bytes, float32 float and float64 double.
+type MapValue interface {
+	string | []byte | int32 | int64 | uint32 | uint64 | bool | float32 | float64
+}
+
+// Deterministic makes the Writer write map entries sorted by key, as the
+// Deterministic option of the protobuf marshaler does: numbers in numeric
+// order, strings by bytes, false before true. Sorting costs an allocation
+// per map.
+func Deterministic() WriterOption {
+	return func(w *Writer) {
+		w.deterministic = true
+	}
+}
+
+// MapStringString writes map<string, string> field num
+func (w *Writer) MapStringString(num int, m map[string]string) {
+	Map(w, num, m)
+}
+
+// Map writes map field num with scalar values.
+func Map[K MapKey, V MapValue](w *Writer, num int, m map[K]V) {
+	mapEntries(w, num, m, func(w *Writer, v V) error {
+		mapField(w, 2, v)
+		return nil
+	})
+}
+
+// MapMessage writes map field num with message values; f writes the
+// fields of each value.
+func MapMessage[K MapKey, V any](w *Writer, num int, m map[K]V, f func(w *Writer, v V) error) {
+	mapEntries(w, num, m, func(w *Writer, v V) error {
+		w.Message(2, func(w *Writer) error { return f(w, v) })
+		return nil
+	})
+}
+
+// mapEntries writes an entry per key of m, with value writing field 2.
+func mapEntries[K MapKey, V any](w *Writer, num int, m map[K]V, value func(w *Writer, v V) error) {
+	if w.err != nil {
+		return
+	}
+	if !w.deterministic {
+		for k, v := range m {
+			w.Message(num, func(w *Writer) error {
+				mapField(w, 1, k)
+				return value(w, v)
+			})
+		}
+		return
+	}
+
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, compareMapKeys)
+	for _, k := range keys {
+		w.Message(num, func(w *Writer) error {
+			mapField(w, 1, k)
+			return value(w, m[k])
+		})
+	}
+}
+
+func compareMapKeys[K MapKey](a, b K) int {
+	switch a := any(a).(type) {
+	case string:
+		return cmp.Compare(a, any(b).(string))
+	case int32:
+		return cmp.Compare(a, any(b).(int32))
+	case int64:
+		return cmp.Compare(a, any(b).(int64))
+	case uint32:
+		return cmp.Compare(a, any(b).(uint32))
+	case uint64:
+		return cmp.Compare(a, any(b).(uint64))
+	case bool:
+		switch b := any(b).(bool); {
+		case a == b:
+			return 0
+		case b:
+			return -1
+		}
+	}
+	return 1
+}
+
+// mapField writes a key or value, whatever its value.
+func mapField[T MapValue](w *Writer, num int, v T) {
+	switch v := any(v).(type) {
+	case string:
+		w.string(num, v)
+	case []byte:
+		w.bytes(num, v)
+	case int32:
+		w.uint64(num, uint64(v))
+	case int64:
+		w.uint64(num, uint64(v))
+	case uint32:
+		w.uint64(num, uint64(v))
+	case uint64:
+		w.uint64(num, v)
+	case bool:
+		if v {
+			w.uint64(num, 1)
+		} else {
+			w.uint64(num, 0)
+		}
+	case float32:
+		w.fixed32(num, math.Float32bits(v))
+	case float64:
+		w.fixed64(num, math.Float64bits(v))
+	}
+}
@@ -54,7 +54,7 @@ func Name(name string) Option {
 
 // MaxSize limits the total number of input bytes accepted by Parse or Read.
 // Zero (the default) means unlimited. On violation, parsing returns
-// ErrorInvalidMessage.
+// ErrorTooLarge (which also matches ErrorInvalidMessage).
 func MaxSize(size uint64) Option {
 	return func(p *RawPB) {
 		p.maxSize = size
@@ -0,0 +1,125 @@
+package rawpb
+
+import "math"
+
+// The Optional methods write fields that track presence, proto2 fields
+// and proto3 optional ones: a nil pointer writes nothing, any other value
+// is written even if it is the default and the Writer omits defaults.
+
+// OptionalBytes writes a bytes field unless v is nil
+func (w *Writer) OptionalBytes(num int, v []byte) {
+	if v != nil {
+		w.bytes(num, v)
+	}
+}
+
+// OptionalString writes a string field unless v is nil
+func (w *Writer) OptionalString(num int, v *string) {
+	if v != nil {
+		w.string(num, *v)
+	}
+}
+
+// OptionalFixed64 writes a 64-bit fixed-size field unless v is nil
+func (w *Writer) OptionalFixed64(num int, v *uint64) {
+	if v != nil {
+		w.fixed64(num, *v)
+	}
+}
+
+// OptionalFixed32 writes a 32-bit fixed-size field unless v is nil
+func (w *Writer) OptionalFixed32(num int, v *uint32) {
+	if v != nil {
+		w.fixed32(num, *v)
+	}
+}
+
+// OptionalUint64 writes an unsigned 64-bit integer field unless v is nil
+func (w *Writer) OptionalUint64(num int, v *uint64) {
+	if v != nil {
+		w.uint64(num, *v)
+	}
+}
+
+// OptionalInt64 writes a signed 64-bit integer field unless v is nil
+func (w *Writer) OptionalInt64(num int, v *int64) {
+	if v != nil {
+		w.uint64(num, uint64(*v))
+	}
+}
+
+// OptionalDouble writes a double-precision floating-point field unless v
+// is nil
+func (w *Writer) OptionalDouble(num int, v *float64) {
+	if v != nil {
+		w.fixed64(num, math.Float64bits(*v))
+	}
+}
+
+// OptionalFloat writes a single-precision floating-point field unless v
+// is nil
+func (w *Writer) OptionalFloat(num int, v *float32) {
+	if v != nil {
+		w.fixed32(num, math.Float32bits(*v))
+	}
+}
+
+// OptionalBool writes a boolean field unless v is nil
+func (w *Writer) OptionalBool(num int, v *bool) {
+	if v != nil {
+		if *v {
+			w.uint64(num, 1)
+		} else {
+			w.uint64(num, 0)
+		}
+	}
+}
+
+// OptionalEnum writes a protocol buffer enum field unless v is nil
+func (w *Writer) OptionalEnum(num int, v *int32) {
+	w.OptionalInt32(num, v)
+}
+
+// OptionalUint32 writes an unsigned 32-bit integer field unless v is nil
+func (w *Writer) OptionalUint32(num int, v *uint32) {
+	if v != nil {
+		w.uint64(num, uint64(*v))
+	}
+}
+
+// OptionalInt32 writes a signed 32-bit integer field unless v is nil
+func (w *Writer) OptionalInt32(num int, v *int32) {
+	if v != nil {
+		w.uint64(num, uint64(*v))
+	}
+}
+
+// OptionalSint32 writes a zigzag-encoded signed 32-bit integer field
+// unless v is nil
+func (w *Writer) OptionalSint32(num int, v *int32) {
+	if v != nil {
+		w.uint64(num, uint64(uint32((*v<<1)^(*v>>31))))
+	}
+}
+
+// OptionalSint64 writes a zigzag-encoded signed 64-bit integer field
+// unless v is nil
+func (w *Writer) OptionalSint64(num int, v *int64) {
+	if v != nil {
+		w.uint64(num, uint64((*v<<1)^(*v>>63)))
+	}
+}
+
+// OptionalSfixed64 writes a signed 64-bit fixed-size field unless v is nil
+func (w *Writer) OptionalSfixed64(num int, v *int64) {
+	if v != nil {
+		w.fixed64(num, uint64(*v))
+	}
+}
+
+// OptionalSfixed32 writes a signed 32-bit fixed-size field unless v is nil
+func (w *Writer) OptionalSfixed32(num int, v *int32) {
+	if v != nil {
+		w.fixed32(num, uint32(*v))
+	}
+}
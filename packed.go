@@ -0,0 +1,225 @@
+package rawpb
+
+import "math"
+
+// Batch decoding of packed repeated fields. The callback API normally calls
+// one function per element; the Packed* options below hand over a whole
+// payload at once instead, and the Decoder.Append* methods decode one into
+// a caller-owned slice. Fixed-size payloads are reinterpreted in place on
+// little-endian platforms when the input is suitably aligned (see
+// packedView), otherwise they are decoded into a reused buffer.
+
+// PackedUint64s registers a batch callback for a repeated uint64 field.
+// A packed payload is decoded in one pass and passed as a single slice; an
+// unpacked element (one varint per tag) arrives as a slice of length one.
+// The slice is reused between calls and is only valid for the duration of
+// the call.
+func PackedUint64s(num int, f func([]uint64) error) Option {
+	return func(p *RawPB) {
+		var buf []uint64
+		p.schema.setPacked(num, callbackTypeVarint, func(v uint64) error {
+			buf = append(buf[:0], v)
+			return f(buf)
+		}, nil, func(b []byte) error {
+			var err error
+			if buf, err = appendVarints(buf[:0], b); err != nil {
+				return err
+			}
+			return f(buf)
+		})
+	}
+}
+
+// PackedInt64s is PackedUint64s for int64 fields.
+func PackedInt64s(num int, f func([]int64) error) Option {
+	return func(p *RawPB) {
+		var buf []int64
+		p.schema.setPacked(num, callbackTypeVarint, func(v uint64) error {
+			buf = append(buf[:0], int64(v))
+			return f(buf)
+		}, nil, func(b []byte) error {
+			var err error
+			if buf, err = appendVarints(buf[:0], b); err != nil {
+				return err
+			}
+			return f(buf)
+		})
+	}
+}
+
+// PackedDoubles registers a batch callback for a repeated double field,
+// with the same slice lifetime rules as PackedUint64s. When the payload can
+// be reinterpreted in place the slice aliases the input buffer. A payload
+// that is not a whole number of doubles fails with ErrorInvalidMessage, as
+// in Decoder.AppendDoubles.
+func PackedDoubles(num int, f func([]float64) error) Option {
+	return func(p *RawPB) {
+		var buf []float64
+		p.schema.setPacked(num, callbackTypeFixed64, func(v uint64) error {
+			buf = append(buf[:0], math.Float64frombits(v))
+			return f(buf)
+		}, nil, func(b []byte) error {
+			if vs, ok := packedView[float64](b); ok {
+				return f(vs)
+			}
+			var err error
+			if buf, err = appendDoubles(buf[:0], b); err != nil {
+				return err
+			}
+			return f(buf)
+		})
+	}
+}
+
+// PackedFloats registers a batch callback for a repeated float field, with
+// the same slice lifetime rules as PackedDoubles.
+func PackedFloats(num int, f func([]float32) error) Option {
+	return func(p *RawPB) {
+		var buf []float32
+		p.schema.setPacked(num, callbackTypeFixed32, nil, func(v uint32) error {
+			buf = append(buf[:0], math.Float32frombits(v))
+			return f(buf)
+		}, func(b []byte) error {
+			if vs, ok := packedView[float32](b); ok {
+				return f(vs)
+			}
+			var err error
+			if buf, err = appendFloats(buf[:0], b); err != nil {
+				return err
+			}
+			return f(buf)
+		})
+	}
+}
+
+// appendVarints decodes a packed varint payload onto dst.
+func appendVarints[T uint64 | int64](dst []T, b []byte) ([]T, error) {
+	for len(b) > 0 {
+		if b[0] < 0x80 {
+			dst = append(dst, T(b[0]))
+			b = b[1:]
+			continue
+		}
+		v, n, err := decodeVarint(b)
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, T(v))
+		b = b[n:]
+	}
+	return dst, nil
+}
+
+// appendDoubles decodes a packed double payload onto dst. A payload that
+// is not a whole number of doubles is ErrorInvalidMessage.
+func appendDoubles(dst []float64, b []byte) ([]float64, error) {
+	if len(b)%8 != 0 {
+		return dst, ErrorInvalidMessage
+	}
+	if vs, ok := packedView[float64](b); ok {
+		return append(dst, vs...), nil
+	}
+	for ; len(b) >= 8; b = b[8:] {
+		v, _ := decodeFixed64(b)
+		dst = append(dst, math.Float64frombits(v))
+	}
+	return dst, nil
+}
+
+// appendFloats decodes a packed float payload onto dst.
+func appendFloats(dst []float32, b []byte) ([]float32, error) {
+	if len(b)%4 != 0 {
+		return dst, ErrorInvalidMessage
+	}
+	if vs, ok := packedView[float32](b); ok {
+		return append(dst, vs...), nil
+	}
+	for ; len(b) >= 4; b = b[4:] {
+		v, _ := decodeFixed32(b)
+		dst = append(dst, math.Float32frombits(v))
+	}
+	return dst, nil
+}
+
+// --- Decoder batch accessors ---
+
+// AppendUint64s appends the current repeated varint field to dst and
+// returns the extended slice. A packed payload is decoded in one pass; an
+// unpacked element appends one value. If a scalar accessor already entered
+// packed continuation for this field, the values Next has not yet yielded
+// are appended as well and the continuation ends.
+func (d *Decoder) AppendUint64s(dst []uint64) []uint64 {
+	return appendDecoderVarints(d, dst)
+}
+
+// AppendInt64s is AppendUint64s for int64 fields.
+func (d *Decoder) AppendInt64s(dst []int64) []int64 {
+	return appendDecoderVarints(d, dst)
+}
+
+// AppendDoubles appends the current repeated double field to dst, like
+// AppendUint64s. A packed payload whose length is not a multiple of 8 sets
+// ErrorInvalidMessage.
+func (d *Decoder) AppendDoubles(dst []float64) []float64 {
+	scalar, b, ok := d.packedPayload(WireFixed64)
+	if !ok {
+		return dst
+	}
+	if scalar {
+		dst = append(dst, math.Float64frombits(d.scalar))
+	}
+	dst, d.err = appendDoubles(dst, b)
+	return dst
+}
+
+// AppendFloats appends the current repeated float field to dst, like
+// AppendDoubles.
+func (d *Decoder) AppendFloats(dst []float32) []float32 {
+	scalar, b, ok := d.packedPayload(WireFixed32)
+	if !ok {
+		return dst
+	}
+	if scalar {
+		dst = append(dst, math.Float32frombits(uint32(d.scalar)))
+	}
+	dst, d.err = appendFloats(dst, b)
+	return dst
+}
+
+func appendDecoderVarints[T uint64 | int64](d *Decoder, dst []T) []T {
+	scalar, b, ok := d.packedPayload(WireVarint)
+	if !ok {
+		return dst
+	}
+	if scalar {
+		dst = append(dst, T(d.scalar))
+	}
+	dst, err := appendVarints(dst, b)
+	if err != nil {
+		d.err = err
+	}
+	return dst
+}
+
+// packedPayload reports what an Append accessor for value-level wire type
+// wt has to decode: the current scalar (for an unpacked element or inside
+// packed continuation) and/or a packed payload. Packed continuation is
+// ended, since the payload is handed over in full. ok is false after an
+// error, including a mismatching wire type.
+func (d *Decoder) packedPayload(wt int) (scalar bool, b []byte, ok bool) {
+	if d.err != nil {
+		return false, nil, false
+	}
+	switch d.wt {
+	case WireLen:
+		return false, d.slice, true
+	case wt:
+		if d.packedWire == wt && d.packedNum == d.num {
+			b = d.packedRem
+			d.packedRem = nil
+		}
+		return true, b, true
+	}
+	d.err = ErrorWrongWireType
+	return false, nil, false
+}
@@ -0,0 +1,9 @@
+//go:build !(386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm)
+
+package rawpb
+
+// packedView never reinterprets on big-endian or unknown platforms; the
+// byte-wise decoders are used instead.
+func packedView[T uint64 | float64 | uint32 | float32](b []byte) ([]T, bool) {
+	return nil, false
+}
@@ -0,0 +1,22 @@
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm
+
+package rawpb
+
+import "unsafe"
+
+// packedView reinterprets a packed fixed-size payload as a slice of T
+// without copying. The wire format is little-endian, which matches the
+// memory layout on this platform, so this only requires b to be aligned
+// for T and a whole number of elements long.
+func packedView[T uint64 | float64 | uint32 | float32](b []byte) ([]T, bool) {
+	var zero T
+	size := int(unsafe.Sizeof(zero))
+	if len(b) == 0 || len(b)%size != 0 {
+		return nil, false
+	}
+	p := unsafe.Pointer(unsafe.SliceData(b))
+	if uintptr(p)%unsafe.Alignof(zero) != 0 {
+		return nil, false
+	}
+	return unsafe.Slice((*T)(p), len(b)/size), true
+}
@@ -0,0 +1,404 @@
+package rawpb
+
+import "math"
+
+// Packed encodings write a repeated scalar field as one length-delimited
+// field holding all values back to back, the proto3 default. The length
+// is computed before the values are appended, so nothing is moved. An
+// empty slice writes nothing, as other encoders do: an empty packed field
+// still decodes as one zero value.
+
+func sizePackedVarints[T uint64 | int64 | uint32 | int32](v []T) int {
+	size := 0
+	for _, x := range v {
+		size += SizeVarint(uint64(x))
+	}
+	return size
+}
+
+func sizePackedZigzags[T int64 | int32](v []T) int {
+	size := 0
+	for _, x := range v {
+		size += SizeVarint(uint64((int64(x) << 1) ^ (int64(x) >> 63)))
+	}
+	return size
+}
+
+func appendPackedVarints[T uint64 | int64 | uint32 | int32](b []byte, num int, v []T) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = AppendTag(b, num, WireLen)
+	b = AppendVarint(b, uint64(sizePackedVarints(v)))
+	for _, x := range v {
+		b = AppendVarint(b, uint64(x))
+	}
+	return b
+}
+
+func appendPackedZigzags[T int64 | int32](b []byte, num int, v []T) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = AppendTag(b, num, WireLen)
+	b = AppendVarint(b, uint64(sizePackedZigzags(v)))
+	for _, x := range v {
+		b = AppendVarint(b, uint64((int64(x)<<1)^(int64(x)>>63)))
+	}
+	return b
+}
+
+func appendPackedFixed64s[T uint64 | int64](b []byte, num int, v []T) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = AppendTag(b, num, WireLen)
+	b = AppendVarint(b, uint64(len(v)*8))
+	for _, x := range v {
+		b = AppendFixed64(b, uint64(x))
+	}
+	return b
+}
+
+func appendPackedFixed32s[T uint32 | int32](b []byte, num int, v []T) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = AppendTag(b, num, WireLen)
+	b = AppendVarint(b, uint64(len(v)*4))
+	for _, x := range v {
+		b = AppendFixed32(b, uint32(x))
+	}
+	return b
+}
+
+// PackedUint64 writes a packed repeated uint64 field
+func (e *Encoder) PackedUint64(num int, v []uint64) {
+	e.buf = appendPackedVarints(e.buf, num, v)
+}
+
+// PackedInt64 writes a packed repeated int64 field
+func (e *Encoder) PackedInt64(num int, v []int64) {
+	e.buf = appendPackedVarints(e.buf, num, v)
+}
+
+// PackedUint32 writes a packed repeated uint32 field
+func (e *Encoder) PackedUint32(num int, v []uint32) {
+	e.buf = appendPackedVarints(e.buf, num, v)
+}
+
+// PackedInt32 writes a packed repeated int32 or enum field
+func (e *Encoder) PackedInt32(num int, v []int32) {
+	e.buf = appendPackedVarints(e.buf, num, v)
+}
+
+// PackedSint32 writes a packed repeated sint32 field using zigzag encoding
+func (e *Encoder) PackedSint32(num int, v []int32) {
+	e.buf = appendPackedZigzags(e.buf, num, v)
+}
+
+// PackedSint64 writes a packed repeated sint64 field using zigzag encoding
+func (e *Encoder) PackedSint64(num int, v []int64) {
+	e.buf = appendPackedZigzags(e.buf, num, v)
+}
+
+// PackedFixed64 writes a packed repeated fixed64 field
+func (e *Encoder) PackedFixed64(num int, v []uint64) {
+	e.buf = appendPackedFixed64s(e.buf, num, v)
+}
+
+// PackedFixed32 writes a packed repeated fixed32 field
+func (e *Encoder) PackedFixed32(num int, v []uint32) {
+	e.buf = appendPackedFixed32s(e.buf, num, v)
+}
+
+// PackedSfixed64 writes a packed repeated sfixed64 field
+func (e *Encoder) PackedSfixed64(num int, v []int64) {
+	e.buf = appendPackedFixed64s(e.buf, num, v)
+}
+
+// PackedSfixed32 writes a packed repeated sfixed32 field
+func (e *Encoder) PackedSfixed32(num int, v []int32) {
+	e.buf = appendPackedFixed32s(e.buf, num, v)
+}
+
+// PackedDouble writes a packed repeated double field
+func (e *Encoder) PackedDouble(num int, v []float64) {
+	if len(v) == 0 {
+		return
+	}
+	e.buf = AppendTag(e.buf, num, WireLen)
+	e.buf = AppendVarint(e.buf, uint64(len(v)*8))
+	for _, x := range v {
+		e.buf = AppendFixed64(e.buf, math.Float64bits(x))
+	}
+}
+
+// PackedFloat writes a packed repeated float field
+func (e *Encoder) PackedFloat(num int, v []float32) {
+	if len(v) == 0 {
+		return
+	}
+	e.buf = AppendTag(e.buf, num, WireLen)
+	e.buf = AppendVarint(e.buf, uint64(len(v)*4))
+	for _, x := range v {
+		e.buf = AppendFixed32(e.buf, math.Float32bits(x))
+	}
+}
+
+// PackedBool writes a packed repeated bool field
+func (e *Encoder) PackedBool(num int, v []bool) {
+	if len(v) == 0 {
+		return
+	}
+	e.buf = AppendTag(e.buf, num, WireLen)
+	e.buf = AppendVarint(e.buf, uint64(len(v)))
+	for _, x := range v {
+		if x {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	}
+}
+
+// PackedUint64 writes a packed repeated uint64 field
+func (w *Writer) PackedUint64(num int, v []uint64) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedUint64(num, v)
+		return
+	}
+	w.enc.PackedUint64(num, v)
+	w.written()
+}
+
+// PackedInt64 writes a packed repeated int64 field
+func (w *Writer) PackedInt64(num int, v []int64) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedInt64(num, v)
+		return
+	}
+	w.enc.PackedInt64(num, v)
+	w.written()
+}
+
+// PackedUint32 writes a packed repeated uint32 field
+func (w *Writer) PackedUint32(num int, v []uint32) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedUint32(num, v)
+		return
+	}
+	w.enc.PackedUint32(num, v)
+	w.written()
+}
+
+// PackedInt32 writes a packed repeated int32 or enum field
+func (w *Writer) PackedInt32(num int, v []int32) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedInt32(num, v)
+		return
+	}
+	w.enc.PackedInt32(num, v)
+	w.written()
+}
+
+// PackedSint32 writes a packed repeated sint32 field using zigzag encoding
+func (w *Writer) PackedSint32(num int, v []int32) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedSint32(num, v)
+		return
+	}
+	w.enc.PackedSint32(num, v)
+	w.written()
+}
+
+// PackedSint64 writes a packed repeated sint64 field using zigzag encoding
+func (w *Writer) PackedSint64(num int, v []int64) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedSint64(num, v)
+		return
+	}
+	w.enc.PackedSint64(num, v)
+	w.written()
+}
+
+// PackedFixed64 writes a packed repeated fixed64 field
+func (w *Writer) PackedFixed64(num int, v []uint64) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedFixed64(num, v)
+		return
+	}
+	w.enc.PackedFixed64(num, v)
+	w.written()
+}
+
+// PackedFixed32 writes a packed repeated fixed32 field
+func (w *Writer) PackedFixed32(num int, v []uint32) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedFixed32(num, v)
+		return
+	}
+	w.enc.PackedFixed32(num, v)
+	w.written()
+}
+
+// PackedSfixed64 writes a packed repeated sfixed64 field
+func (w *Writer) PackedSfixed64(num int, v []int64) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedSfixed64(num, v)
+		return
+	}
+	w.enc.PackedSfixed64(num, v)
+	w.written()
+}
+
+// PackedSfixed32 writes a packed repeated sfixed32 field
+func (w *Writer) PackedSfixed32(num int, v []int32) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedSfixed32(num, v)
+		return
+	}
+	w.enc.PackedSfixed32(num, v)
+	w.written()
+}
+
+// PackedDouble writes a packed repeated double field
+func (w *Writer) PackedDouble(num int, v []float64) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedDouble(num, v)
+		return
+	}
+	w.enc.PackedDouble(num, v)
+	w.written()
+}
+
+// PackedFloat writes a packed repeated float field
+func (w *Writer) PackedFloat(num int, v []float32) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedFloat(num, v)
+		return
+	}
+	w.enc.PackedFloat(num, v)
+	w.written()
+}
+
+// PackedBool writes a packed repeated bool field
+func (w *Writer) PackedBool(num int, v []bool) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.PackedBool(num, v)
+		return
+	}
+	w.enc.PackedBool(num, v)
+	w.written()
+}
+
+// packed counts a packed field with a payload of size bytes.
+func (s *sizer) packed(num, n, size int) {
+	if n > 0 {
+		s.n += SizeTag(num) + SizeBytes(size)
+	}
+}
+
+// PackedUint64 counts a packed repeated uint64 field
+func (s *sizer) PackedUint64(num int, v []uint64) {
+	s.packed(num, len(v), sizePackedVarints(v))
+}
+
+// PackedInt64 counts a packed repeated int64 field
+func (s *sizer) PackedInt64(num int, v []int64) {
+	s.packed(num, len(v), sizePackedVarints(v))
+}
+
+// PackedUint32 counts a packed repeated uint32 field
+func (s *sizer) PackedUint32(num int, v []uint32) {
+	s.packed(num, len(v), sizePackedVarints(v))
+}
+
+// PackedInt32 counts a packed repeated int32 or enum field
+func (s *sizer) PackedInt32(num int, v []int32) {
+	s.packed(num, len(v), sizePackedVarints(v))
+}
+
+// PackedSint32 counts a packed repeated sint32 field
+func (s *sizer) PackedSint32(num int, v []int32) {
+	s.packed(num, len(v), sizePackedZigzags(v))
+}
+
+// PackedSint64 counts a packed repeated sint64 field
+func (s *sizer) PackedSint64(num int, v []int64) {
+	s.packed(num, len(v), sizePackedZigzags(v))
+}
+
+// PackedFixed64 counts a packed repeated fixed64 field
+func (s *sizer) PackedFixed64(num int, v []uint64) {
+	s.packed(num, len(v), len(v)*8)
+}
+
+// PackedFixed32 counts a packed repeated fixed32 field
+func (s *sizer) PackedFixed32(num int, v []uint32) {
+	s.packed(num, len(v), len(v)*4)
+}
+
+// PackedSfixed64 counts a packed repeated sfixed64 field
+func (s *sizer) PackedSfixed64(num int, v []int64) {
+	s.packed(num, len(v), len(v)*8)
+}
+
+// PackedSfixed32 counts a packed repeated sfixed32 field
+func (s *sizer) PackedSfixed32(num int, v []int32) {
+	s.packed(num, len(v), len(v)*4)
+}
+
+// PackedDouble counts a packed repeated double field
+func (s *sizer) PackedDouble(num int, v []float64) {
+	s.packed(num, len(v), len(v)*8)
+}
+
+// PackedFloat counts a packed repeated float field
+func (s *sizer) PackedFloat(num int, v []float32) {
+	s.packed(num, len(v), len(v)*4)
+}
+
+// PackedBool counts a packed repeated bool field
+func (s *sizer) PackedBool(num int, v []bool) {
+	s.packed(num, len(v), len(v))
+}
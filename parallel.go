@@ -0,0 +1,153 @@
+package rawpb
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// chunksPerWorker controls how finely ParseParallel splits the input. More
+// chunks than workers keeps all workers busy when fields vary in size.
+const chunksPerWorker = 4
+
+// ParseParallel parses body like Parse, but spreads its top-level fields
+// over several goroutines. It is meant for messages dominated by one large
+// repeated field, such as the TimeSeries of a remote write WriteRequest.
+//
+// A cheap first pass with a Decoder finds where every top-level field
+// starts and ends and groups consecutive fields into chunks. newWorker is
+// called once per worker, up front, and must return a schema whose
+// callbacks only touch that worker's own state. Each chunk is parsed with
+// the schema of whichever worker picks it up; the top-level Begin and End
+// callbacks therefore run once per chunk, which makes them the natural
+// place to reset and finish per-chunk state.
+//
+// After a chunk is parsed, merge(worker, chunk) is called for it. Merge
+// calls are serialized and always happen in chunk order (which is input
+// order), so folding per-worker results into shared state from merge is
+// deterministic and needs no locking. A worker does not start its next
+// chunk before its previous chunk was merged. merge may be nil.
+//
+// Errors follow sequential semantics: the returned error is the one from
+// the earliest failing chunk (or merge call), merge is not called for that
+// chunk or any later one, and later chunks are skipped as soon as a failure
+// is known. A malformed top level is reported before any callback runs.
+// The MaxSize option of the first worker's schema applies to body as a
+// whole.
+func ParseParallel(body []byte, workers int, newWorker func(worker int) *RawPB, merge func(worker, chunk int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	schemas := make([]*RawPB, workers)
+	for i := range schemas {
+		schemas[i] = newWorker(i)
+	}
+
+	if m := schemas[0].maxSize; m > 0 && uint64(len(body)) > m {
+		return ErrorTooLarge
+	}
+
+	chunks, err := splitTopLevel(body, workers*chunksPerWorker)
+	if err != nil {
+		return err
+	}
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+
+	var (
+		next     atomic.Int64 // next chunk to hand out
+		failed   atomic.Int64 // lowest chunk known to have failed
+		mu       sync.Mutex
+		turn     = sync.NewCond(&mu)
+		merged   int // chunks merged (or skipped) so far
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	failed.Store(math.MaxInt64)
+
+	work := func(w int) {
+		defer wg.Done()
+		pb := schemas[w]
+		for {
+			i := int(next.Add(1) - 1)
+			if i >= len(chunks) {
+				return
+			}
+
+			var err error
+			if int64(i) < failed.Load() {
+				err = pb.Parse(chunks[i])
+				if err != nil {
+					lowerFailed(&failed, int64(i))
+				}
+			}
+
+			mu.Lock()
+			for merged != i {
+				turn.Wait()
+			}
+			if firstErr == nil {
+				if err != nil {
+					firstErr = err
+				} else if merge != nil {
+					if err = merge(w, i); err != nil {
+						firstErr = err
+						lowerFailed(&failed, int64(i))
+					}
+				}
+			}
+			merged++
+			turn.Broadcast()
+			mu.Unlock()
+		}
+	}
+
+	wg.Add(workers)
+	for w := 1; w < workers; w++ {
+		go work(w)
+	}
+	work(0)
+	wg.Wait()
+
+	return firstErr
+}
+
+func lowerFailed(failed *atomic.Int64, i int64) {
+	for {
+		cur := failed.Load()
+		if i >= cur || failed.CompareAndSwap(cur, i) {
+			return
+		}
+	}
+}
+
+// splitTopLevel walks the top-level fields of body and groups consecutive
+// fields into at most about n chunks of similar byte size. Field
+// boundaries are never split.
+func splitTopLevel(body []byte, n int) ([][]byte, error) {
+	if len(body) == 0 {
+		// one empty chunk, so Begin and End still run as they do for Parse
+		return [][]byte{body}, nil
+	}
+	target := len(body)/n + 1
+
+	var chunks [][]byte
+	var d Decoder
+	d.Reset(body)
+	start := 0
+	for d.Next() {
+		if d.r.offset-start >= target {
+			chunks = append(chunks, body[start:d.r.offset])
+			start = d.r.offset
+		}
+	}
+	if err := d.Err(); err != nil {
+		return nil, err
+	}
+	if start < len(body) {
+		chunks = append(chunks, body[start:])
+	}
+	return chunks, nil
+}
@@ -0,0 +1,164 @@
+package rawpb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Find advances to the next field with number num, skipping all others. It
+// returns false at the end of input or on error (see Err). Like Next, it
+// continues from the current position, so repeated Find calls visit every
+// occurrence of a repeated field.
+func (d *Decoder) Find(num int) bool {
+	for d.Next() {
+		if d.num == num {
+			return true
+		}
+	}
+	return false
+}
+
+// Path selects fields in nested messages by field number, without decoding
+// anything off the path. Build one with ParsePath or MustPath and reuse it;
+// a Path is immutable and safe for concurrent use.
+type Path struct {
+	steps []pathStep
+	text  string
+}
+
+type pathStep struct {
+	num   int
+	index int // occurrence to follow; -1 follows all of them
+}
+
+// pathDecoders holds one Decoder per path step. The decoders are handed to
+// callbacks by pointer, which would move them to the heap on every Walk.
+var pathDecoders = sync.Pool{
+	New: func() any { return new([]Decoder) },
+}
+
+// errPathStop ends a walk early from First.
+var errPathStop = errors.New("path: stop")
+
+// ParsePath compiles a path expression: field numbers separated by dots,
+// each optionally followed by an occurrence selector. "[*]" follows every
+// occurrence of a repeated field, "[i]" only the i-th (from zero); a bare
+// number is the same as "[0]". For example "1.2[*].1" selects field 1 of
+// every field 2 inside the first field 1.
+//
+// All steps but the last must be length-delimited submessages. A mismatch
+// in the input makes Walk and First fail with ErrorWrongWireType.
+func ParsePath(s string) (*Path, error) {
+	p := &Path{text: s}
+	for _, part := range strings.Split(s, ".") {
+		step := pathStep{}
+		num, sel, hasSel := strings.Cut(part, "[")
+		if hasSel {
+			if !strings.HasSuffix(sel, "]") {
+				return nil, fmt.Errorf("invalid path %q: unterminated selector in %q", s, part)
+			}
+			sel = strings.TrimSuffix(sel, "]")
+			if sel == "*" {
+				step.index = -1
+			} else {
+				i, err := strconv.Atoi(sel)
+				if err != nil || i < 0 {
+					return nil, fmt.Errorf("invalid path %q: bad selector %q", s, sel)
+				}
+				step.index = i
+			}
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 1 || n > maxFieldNumber {
+			return nil, fmt.Errorf("invalid path %q: bad field number %q", s, num)
+		}
+		step.num = n
+		p.steps = append(p.steps, step)
+	}
+	return p, nil
+}
+
+// MustPath is like ParsePath but panics on a malformed expression. It is
+// meant for package-level variables.
+func MustPath(s string) *Path {
+	p, err := ParsePath(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the expression the path was parsed from.
+func (p *Path) String() string {
+	return p.text
+}
+
+// Walk calls f for every field of body the path selects, in input order.
+// f receives a Decoder positioned on the field, as after Next: read the
+// value with any accessor, or the raw payload with Bytes. A scalar accessor
+// on a packed field enters packed continuation as usual, so with a "[*]"
+// last step f is called once per packed value. An error from f stops the
+// walk and is returned.
+func (p *Path) Walk(body []byte, f func(d *Decoder) error) error {
+	ds := pathDecoders.Get().(*[]Decoder)
+	if cap(*ds) < len(p.steps) {
+		*ds = make([]Decoder, len(p.steps))
+	}
+	*ds = (*ds)[:len(p.steps)]
+	(*ds)[0].Reset(body)
+
+	err := p.walk(*ds, 0, f)
+
+	clear(*ds)
+	pathDecoders.Put(ds)
+	return err
+}
+
+// First returns a Decoder positioned on the first field the path selects.
+// ok is false when nothing matches.
+func (p *Path) First(body []byte) (d Decoder, ok bool, err error) {
+	err = p.Walk(body, func(m *Decoder) error {
+		d = *m
+		ok = true
+		return errPathStop
+	})
+	if err == errPathStop {
+		err = nil
+	}
+	return d, ok, err
+}
+
+func (p *Path) walk(ds []Decoder, depth int, f func(d *Decoder) error) error {
+	d := &ds[depth]
+	step := p.steps[depth]
+	last := depth == len(p.steps)-1
+	seen := 0
+	for d.Find(step.num) {
+		if step.index >= 0 && seen < step.index {
+			seen++
+			continue
+		}
+		seen++
+
+		if last {
+			if err := f(d); err != nil {
+				return err
+			}
+		} else {
+			ds[depth+1] = d.Submessage()
+			if err := d.Err(); err != nil {
+				return err
+			}
+			if err := p.walk(ds, depth+1, f); err != nil {
+				return err
+			}
+		}
+		if step.index >= 0 {
+			return nil
+		}
+	}
+	return d.Err()
+}
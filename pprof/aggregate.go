@@ -0,0 +1,83 @@
+package pprof
+
+// SumBy streams all samples and sums Value[valueIndex] grouped by the key
+// returned for each sample. Samples for which key returns "" are skipped.
+// Only the result map is materialized.
+func (p *Profile) SumBy(valueIndex int, key func(s *Sample) string) (map[string]int64, error) {
+	res := make(map[string]int64)
+	err := p.Samples(func(s *Sample) error {
+		if valueIndex < 0 || valueIndex >= len(s.Value) {
+			return nil
+		}
+		k := key(s)
+		if k == "" {
+			return nil
+		}
+		res[k] += s.Value[valueIndex]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SumByFunction sums Value[valueIndex] by the name of the leaf function of
+// each sample ("flat" in pprof terms).
+func (p *Profile) SumByFunction(valueIndex int) (map[string]int64, error) {
+	if err := p.buildIndex(); err != nil {
+		return nil, err
+	}
+	return p.SumBy(valueIndex, p.LeafFunction)
+}
+
+// LeafFunction returns the name of the innermost function of s, or "" if it
+// cannot be resolved. The first call builds a location and function index;
+// later calls are map lookups.
+func (p *Profile) LeafFunction(s *Sample) string {
+	if len(s.LocationID) == 0 || p.buildIndex() != nil {
+		return ""
+	}
+	return p.String(p.locLeaf[s.LocationID[0]])
+}
+
+// FunctionName resolves a function id to its name.
+func (p *Profile) FunctionName(id uint64) string {
+	if p.buildIndex() != nil {
+		return ""
+	}
+	return p.String(p.funcName[id])
+}
+
+// buildIndex records, for every location, the name index of its leaf
+// function. Only ids and string indexes are kept, not decoded messages.
+func (p *Profile) buildIndex() error {
+	if p.indexed {
+		return nil
+	}
+
+	funcName := make(map[uint64]int64)
+	err := p.Functions(func(fn *Function) error {
+		funcName[fn.ID] = fn.Name
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	locLeaf := make(map[uint64]int64)
+	err = p.Locations(func(l *Location) error {
+		if len(l.Line) > 0 {
+			locLeaf[l.ID] = funcName[l.Line[0].FunctionID]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.funcName = funcName
+	p.locLeaf = locLeaf
+	p.indexed = true
+	return nil
+}
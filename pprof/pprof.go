@@ -0,0 +1,289 @@
+// Package pprof streams perftools.profiles.Profile messages (the format
+// written by runtime/pprof) on top of rawpb.Decoder.
+//
+// Unlike github.com/google/pprof/profile, Parse does not materialize the
+// profile. It keeps the decoded bytes, records where the string table
+// entries live, and walks samples, locations, functions and mappings on
+// demand with reused structs. String table indexes stay as integers until
+// the caller resolves them with Profile.String.
+package pprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"unsafe"
+
+	"github.com/lomik/rawpb"
+)
+
+// ValueType describes the type and unit of a sample value. Both fields are
+// string table indexes.
+type ValueType struct {
+	Type int64
+	Unit int64
+}
+
+// Label is a sample label. Key, Str and NumUnit are string table indexes.
+type Label struct {
+	Key     int64
+	Str     int64
+	Num     int64
+	NumUnit int64
+}
+
+// Sample is a single profile sample. LocationID[0] is the leaf.
+type Sample struct {
+	LocationID []uint64
+	Value      []int64
+	Label      []Label
+}
+
+// Line is a source line of a location. When a location has several lines,
+// the last one is the caller into which the preceding ones were inlined.
+type Line struct {
+	FunctionID uint64
+	Line       int64
+	Column     int64
+}
+
+// Location is a unique place in the program, commonly a return address.
+type Location struct {
+	ID        uint64
+	MappingID uint64
+	Address   uint64
+	Line      []Line
+	IsFolded  bool
+}
+
+// Function is a program function. Name, SystemName and Filename are string
+// table indexes.
+type Function struct {
+	ID         uint64
+	Name       int64
+	SystemName int64
+	Filename   int64
+	StartLine  int64
+}
+
+// Mapping describes a mapped binary. Filename and BuildID are string table
+// indexes.
+type Mapping struct {
+	ID              uint64
+	MemoryStart     uint64
+	MemoryLimit     uint64
+	FileOffset      uint64
+	Filename        int64
+	BuildID         int64
+	HasFunctions    bool
+	HasFilenames    bool
+	HasLineNumbers  bool
+	HasInlineFrames bool
+}
+
+// Profile is an indexed, lazily decoded profile.
+type Profile struct {
+	data    []byte
+	strings []string
+
+	sampleTypes       []ValueType
+	periodType        ValueType
+	period            int64
+	timeNanos         int64
+	durationNanos     int64
+	dropFrames        int64
+	keepFrames        int64
+	comments          []int64
+	defaultSampleType int64
+
+	// built on first use by the aggregation helpers
+	indexed  bool
+	locLeaf  map[uint64]int64 // location id -> leaf function name
+	funcName map[uint64]int64 // function id -> name
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// DefaultMaxSize is the default cap on a profile, after decompression.
+const DefaultMaxSize = 256 << 20
+
+// Option configures Parse and ParseReader.
+type Option func(*options)
+
+type options struct {
+	maxSize int64
+}
+
+// MaxSize caps the profile (default DefaultMaxSize): the decompressed
+// data of gzipped input, and the input itself otherwise. Larger profiles
+// fail with rawpb.ErrorTooLarge, and a gzip bomb is not decompressed past
+// the cap. Zero means unlimited.
+func MaxSize(size int64) Option {
+	return func(o *options) {
+		o.maxSize = size
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{maxSize: DefaultMaxSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// readAll reads r whole, failing with rawpb.ErrorTooLarge past maxSize.
+func readAll(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, rawpb.ErrorTooLarge
+	}
+	return data, nil
+}
+
+// Parse indexes an encoded profile. Gzip-compressed input (as written by
+// runtime/pprof) is decompressed transparently. When data is not
+// compressed, the returned Profile aliases it and data must not be
+// modified while the Profile is in use.
+func Parse(data []byte, opts ...Option) (*Profile, error) {
+	o := newOptions(opts)
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = readAll(zr, o.maxSize)
+		if err != nil {
+			return nil, err
+		}
+	} else if o.maxSize > 0 && int64(len(data)) > o.maxSize {
+		return nil, rawpb.ErrorTooLarge
+	}
+
+	p := &Profile{data: data}
+	if err := p.index(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ParseReader reads the whole stream and indexes it like Parse. MaxSize
+// also caps what is read from r.
+func ParseReader(r io.Reader, opts ...Option) (*Profile, error) {
+	data, err := readAll(r, newOptions(opts).maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, opts...)
+}
+
+// index walks the top level once, recording the string table and the small
+// scalar header fields. Samples, locations, functions and mappings are left
+// encoded.
+func (p *Profile) index() error {
+	var d rawpb.Decoder
+	d.Reset(p.data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			sub := d.Submessage()
+			vt, err := decodeValueType(&sub)
+			if err != nil {
+				return err
+			}
+			p.sampleTypes = append(p.sampleTypes, vt)
+		case 6:
+			b := d.Bytes()
+			p.strings = append(p.strings, unsafe.String(unsafe.SliceData(b), len(b)))
+		case 7:
+			p.dropFrames = d.Int64()
+		case 8:
+			p.keepFrames = d.Int64()
+		case 9:
+			p.timeNanos = d.Int64()
+		case 10:
+			p.durationNanos = d.Int64()
+		case 11:
+			sub := d.Submessage()
+			vt, err := decodeValueType(&sub)
+			if err != nil {
+				return err
+			}
+			p.periodType = vt
+		case 12:
+			p.period = d.Int64()
+		case 13:
+			p.comments = append(p.comments, d.Int64())
+		case 14:
+			p.defaultSampleType = d.Int64()
+		}
+	}
+	return d.Err()
+}
+
+// String resolves a string table index. Out-of-range indexes resolve to
+// the empty string, as does index 0 by definition. The returned string
+// aliases the profile data.
+func (p *Profile) String(i int64) string {
+	if i <= 0 || i >= int64(len(p.strings)) {
+		return ""
+	}
+	return p.strings[i]
+}
+
+// SampleTypes returns the type of each entry of Sample.Value.
+func (p *Profile) SampleTypes() []ValueType { return p.sampleTypes }
+
+// PeriodType returns the kind of events between sampled occurrences.
+func (p *Profile) PeriodType() ValueType { return p.periodType }
+
+// Period returns the number of events between sampled occurrences.
+func (p *Profile) Period() int64 { return p.period }
+
+// TimeNanos returns the collection time in nanoseconds since the epoch.
+func (p *Profile) TimeNanos() int64 { return p.timeNanos }
+
+// DurationNanos returns the duration of the profile.
+func (p *Profile) DurationNanos() int64 { return p.durationNanos }
+
+// DropFrames returns the string index of the drop_frames regexp.
+func (p *Profile) DropFrames() int64 { return p.dropFrames }
+
+// KeepFrames returns the string index of the keep_frames regexp.
+func (p *Profile) KeepFrames() int64 { return p.keepFrames }
+
+// Comments returns string indexes of free-form comments.
+func (p *Profile) Comments() []int64 { return p.comments }
+
+// DefaultSampleType returns the string index of the preferred sample type.
+func (p *Profile) DefaultSampleType() int64 { return p.defaultSampleType }
+
+// SampleTypeIndex returns the position in Sample.Value of the sample type
+// named typ, or -1.
+func (p *Profile) SampleTypeIndex(typ string) int {
+	for i, vt := range p.sampleTypes {
+		if p.String(vt.Type) == typ {
+			return i
+		}
+	}
+	return -1
+}
+
+func decodeValueType(d *rawpb.Decoder) (ValueType, error) {
+	var vt ValueType
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			vt.Type = d.Int64()
+		case 2:
+			vt.Unit = d.Int64()
+		}
+	}
+	return vt, d.Err()
+}
@@ -0,0 +1,205 @@
+package pprof
+
+import (
+	"github.com/lomik/rawpb"
+)
+
+// Samples calls f for every sample in file order. The *Sample and its
+// slices are reused between calls; copy anything that must outlive f.
+// A non-nil error from f stops the walk and is returned.
+func (p *Profile) Samples(f func(s *Sample) error) error {
+	var s Sample
+	var d rawpb.Decoder
+	d.Reset(p.data)
+	for d.Next() {
+		if d.Num() != 2 {
+			continue
+		}
+		sub := d.Submessage()
+		if err := decodeSample(&sub, &s); err != nil {
+			return err
+		}
+		if err := f(&s); err != nil {
+			return err
+		}
+	}
+	return d.Err()
+}
+
+// Locations calls f for every location. The *Location is reused between
+// calls.
+func (p *Profile) Locations(f func(l *Location) error) error {
+	var l Location
+	var d rawpb.Decoder
+	d.Reset(p.data)
+	for d.Next() {
+		if d.Num() != 4 {
+			continue
+		}
+		sub := d.Submessage()
+		if err := decodeLocation(&sub, &l); err != nil {
+			return err
+		}
+		if err := f(&l); err != nil {
+			return err
+		}
+	}
+	return d.Err()
+}
+
+// Functions calls f for every function. The *Function is reused between
+// calls.
+func (p *Profile) Functions(f func(fn *Function) error) error {
+	var fn Function
+	var d rawpb.Decoder
+	d.Reset(p.data)
+	for d.Next() {
+		if d.Num() != 5 {
+			continue
+		}
+		sub := d.Submessage()
+		if err := decodeFunction(&sub, &fn); err != nil {
+			return err
+		}
+		if err := f(&fn); err != nil {
+			return err
+		}
+	}
+	return d.Err()
+}
+
+// Mappings calls f for every mapping. The *Mapping is reused between calls.
+func (p *Profile) Mappings(f func(m *Mapping) error) error {
+	var m Mapping
+	var d rawpb.Decoder
+	d.Reset(p.data)
+	for d.Next() {
+		if d.Num() != 3 {
+			continue
+		}
+		sub := d.Submessage()
+		if err := decodeMapping(&sub, &m); err != nil {
+			return err
+		}
+		if err := f(&m); err != nil {
+			return err
+		}
+	}
+	return d.Err()
+}
+
+func decodeSample(d *rawpb.Decoder, s *Sample) error {
+	s.LocationID = s.LocationID[:0]
+	s.Value = s.Value[:0]
+	s.Label = s.Label[:0]
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			s.LocationID = append(s.LocationID, d.Uint64())
+		case 2:
+			s.Value = append(s.Value, d.Int64())
+		case 3:
+			var l Label
+			sub := d.Submessage()
+			for sub.Next() {
+				switch sub.Num() {
+				case 1:
+					l.Key = sub.Int64()
+				case 2:
+					l.Str = sub.Int64()
+				case 3:
+					l.Num = sub.Int64()
+				case 4:
+					l.NumUnit = sub.Int64()
+				}
+			}
+			if err := sub.Err(); err != nil {
+				return err
+			}
+			s.Label = append(s.Label, l)
+		}
+	}
+	return d.Err()
+}
+
+func decodeLocation(d *rawpb.Decoder, l *Location) error {
+	*l = Location{Line: l.Line[:0]}
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			l.ID = d.Uint64()
+		case 2:
+			l.MappingID = d.Uint64()
+		case 3:
+			l.Address = d.Uint64()
+		case 4:
+			var ln Line
+			sub := d.Submessage()
+			for sub.Next() {
+				switch sub.Num() {
+				case 1:
+					ln.FunctionID = sub.Uint64()
+				case 2:
+					ln.Line = sub.Int64()
+				case 3:
+					ln.Column = sub.Int64()
+				}
+			}
+			if err := sub.Err(); err != nil {
+				return err
+			}
+			l.Line = append(l.Line, ln)
+		case 5:
+			l.IsFolded = d.Bool()
+		}
+	}
+	return d.Err()
+}
+
+func decodeFunction(d *rawpb.Decoder, fn *Function) error {
+	*fn = Function{}
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			fn.ID = d.Uint64()
+		case 2:
+			fn.Name = d.Int64()
+		case 3:
+			fn.SystemName = d.Int64()
+		case 4:
+			fn.Filename = d.Int64()
+		case 5:
+			fn.StartLine = d.Int64()
+		}
+	}
+	return d.Err()
+}
+
+func decodeMapping(d *rawpb.Decoder, m *Mapping) error {
+	*m = Mapping{}
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.ID = d.Uint64()
+		case 2:
+			m.MemoryStart = d.Uint64()
+		case 3:
+			m.MemoryLimit = d.Uint64()
+		case 4:
+			m.FileOffset = d.Uint64()
+		case 5:
+			m.Filename = d.Int64()
+		case 6:
+			m.BuildID = d.Int64()
+		case 7:
+			m.HasFunctions = d.Bool()
+		case 8:
+			m.HasFilenames = d.Bool()
+		case 9:
+			m.HasLineNumbers = d.Bool()
+		case 10:
+			m.HasInlineFrames = d.Bool()
+		}
+	}
+	return d.Err()
+}
@@ -0,0 +1,282 @@
+package promproto
+
+import (
+	"github.com/lomik/rawpb"
+)
+
+// Decode parses a single (non-delimited) MetricFamily message into mf,
+// reusing its slices.
+//
+// Strings in mf alias body. They are only valid while body is left
+// untouched; copy them (strings.Clone) to retain them longer.
+func Decode(body []byte, mf *MetricFamily) error {
+	mf.Reset()
+
+	var d rawpb.Decoder
+	d.Reset(body)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			mf.Name = d.UnsafeString()
+		case 2:
+			mf.Help = d.UnsafeString()
+		case 3:
+			mf.Type = MetricType(d.Int32())
+		case 4:
+			sub := d.Submessage()
+			if err := decodeMetric(&sub, mf.next()); err != nil {
+				return err
+			}
+		case 5:
+			mf.Unit = d.UnsafeString()
+		}
+	}
+	return d.Err()
+}
+
+func decodeMetric(d *rawpb.Decoder, m *Metric) error {
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			sub := d.Submessage()
+			if err := decodeLabel(&sub, grow(&m.Label)); err != nil {
+				return err
+			}
+		case 2:
+			sub := d.Submessage()
+			for sub.Next() {
+				if sub.Num() == 1 {
+					m.Gauge.Value = sub.Double()
+				}
+			}
+			if err := sub.Err(); err != nil {
+				return err
+			}
+		case 3:
+			sub := d.Submessage()
+			if err := decodeCounter(&sub, &m.Counter); err != nil {
+				return err
+			}
+		case 4:
+			sub := d.Submessage()
+			if err := decodeSummary(&sub, &m.Summary); err != nil {
+				return err
+			}
+		case 5:
+			sub := d.Submessage()
+			for sub.Next() {
+				if sub.Num() == 1 {
+					m.Untyped.Value = sub.Double()
+				}
+			}
+			if err := sub.Err(); err != nil {
+				return err
+			}
+		case 6:
+			m.TimestampMs = d.Int64()
+		case 7:
+			sub := d.Submessage()
+			if err := decodeHistogram(&sub, &m.Histogram); err != nil {
+				return err
+			}
+		}
+	}
+	return d.Err()
+}
+
+func decodeLabel(d *rawpb.Decoder, l *LabelPair) error {
+	l.Name = ""
+	l.Value = ""
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			l.Name = d.UnsafeString()
+		case 2:
+			l.Value = d.UnsafeString()
+		}
+	}
+	return d.Err()
+}
+
+func decodeTimestamp(d *rawpb.Decoder, t *Timestamp) error {
+	*t = Timestamp{}
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			t.Seconds = d.Int64()
+		case 2:
+			t.Nanos = d.Int32()
+		}
+	}
+	return d.Err()
+}
+
+func decodeExemplar(d *rawpb.Decoder, e *Exemplar) error {
+	e.reset()
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			sub := d.Submessage()
+			if err := decodeLabel(&sub, grow(&e.Label)); err != nil {
+				return err
+			}
+		case 2:
+			e.Value = d.Double()
+		case 3:
+			sub := d.Submessage()
+			if err := decodeTimestamp(&sub, &e.Timestamp); err != nil {
+				return err
+			}
+		}
+	}
+	return d.Err()
+}
+
+func decodeCounter(d *rawpb.Decoder, c *Counter) error {
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			c.Value = d.Double()
+		case 2:
+			sub := d.Submessage()
+			if err := decodeExemplar(&sub, &c.Exemplar); err != nil {
+				return err
+			}
+			c.HasExemplar = true
+		case 3:
+			sub := d.Submessage()
+			if err := decodeTimestamp(&sub, &c.CreatedTimestamp); err != nil {
+				return err
+			}
+		}
+	}
+	return d.Err()
+}
+
+func decodeSummary(d *rawpb.Decoder, s *Summary) error {
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			s.SampleCount = d.Uint64()
+		case 2:
+			s.SampleSum = d.Double()
+		case 3:
+			q := grow(&s.Quantile)
+			*q = Quantile{}
+			sub := d.Submessage()
+			for sub.Next() {
+				switch sub.Num() {
+				case 1:
+					q.Quantile = sub.Double()
+				case 2:
+					q.Value = sub.Double()
+				}
+			}
+			if err := sub.Err(); err != nil {
+				return err
+			}
+		case 4:
+			sub := d.Submessage()
+			if err := decodeTimestamp(&sub, &s.CreatedTimestamp); err != nil {
+				return err
+			}
+		}
+	}
+	return d.Err()
+}
+
+func decodeBucket(d *rawpb.Decoder, b *Bucket) error {
+	b.CumulativeCount = 0
+	b.CumulativeCountFloat = 0
+	b.UpperBound = 0
+	b.HasExemplar = false
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			b.CumulativeCount = d.Uint64()
+		case 2:
+			b.UpperBound = d.Double()
+		case 3:
+			sub := d.Submessage()
+			if err := decodeExemplar(&sub, &b.Exemplar); err != nil {
+				return err
+			}
+			b.HasExemplar = true
+		case 4:
+			b.CumulativeCountFloat = d.Double()
+		}
+	}
+	return d.Err()
+}
+
+func decodeSpan(d *rawpb.Decoder, s *BucketSpan) error {
+	*s = BucketSpan{}
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			s.Offset = d.Sint32()
+		case 2:
+			s.Length = d.Uint32()
+		}
+	}
+	return d.Err()
+}
+
+func decodeHistogram(d *rawpb.Decoder, h *Histogram) error {
+	for d.Next() {
+		num := d.Num()
+		if num >= 5 && num <= 14 {
+			h.Native = true
+		}
+		switch num {
+		case 1:
+			h.SampleCount = d.Uint64()
+		case 2:
+			h.SampleSum = d.Double()
+		case 3:
+			sub := d.Submessage()
+			if err := decodeBucket(&sub, grow(&h.Bucket)); err != nil {
+				return err
+			}
+		case 4:
+			h.SampleCountFloat = d.Double()
+		case 5:
+			h.Schema = d.Sint32()
+		case 6:
+			h.ZeroThreshold = d.Double()
+		case 7:
+			h.ZeroCount = d.Uint64()
+		case 8:
+			h.ZeroCountFloat = d.Double()
+		case 9:
+			sub := d.Submessage()
+			if err := decodeSpan(&sub, grow(&h.NegativeSpan)); err != nil {
+				return err
+			}
+		case 10:
+			h.NegativeDelta = append(h.NegativeDelta, d.Sint64())
+		case 11:
+			h.NegativeCount = append(h.NegativeCount, d.Double())
+		case 12:
+			sub := d.Submessage()
+			if err := decodeSpan(&sub, grow(&h.PositiveSpan)); err != nil {
+				return err
+			}
+		case 13:
+			h.PositiveDelta = append(h.PositiveDelta, d.Sint64())
+		case 14:
+			h.PositiveCount = append(h.PositiveCount, d.Double())
+		case 15:
+			sub := d.Submessage()
+			if err := decodeTimestamp(&sub, &h.CreatedTimestamp); err != nil {
+				return err
+			}
+		case 16:
+			sub := d.Submessage()
+			if err := decodeExemplar(&sub, grow(&h.Exemplars)); err != nil {
+				return err
+			}
+		}
+	}
+	return d.Err()
+}
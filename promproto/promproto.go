@@ -0,0 +1,209 @@
+// Package promproto reads and writes the Prometheus protobuf exposition
+// format: a stream of varint-delimited io.prometheus.client.MetricFamily
+// messages, as served with ContentType.
+//
+// The types in this package mirror client_model's metrics.proto but are
+// plain value structs designed for reuse: Reader decodes every family into
+// the same MetricFamily, truncating slices instead of reallocating them, so
+// steady-state scraping does not allocate.
+package promproto
+
+// ContentType is the HTTP Content-Type of the delimited protobuf exposition
+// format.
+const ContentType = "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited"
+
+// MetricType is the io.prometheus.client.MetricType enum.
+type MetricType int32
+
+const (
+	MetricTypeCounter        MetricType = 0
+	MetricTypeGauge          MetricType = 1
+	MetricTypeSummary        MetricType = 2
+	MetricTypeUntyped        MetricType = 3
+	MetricTypeHistogram      MetricType = 4
+	MetricTypeGaugeHistogram MetricType = 5
+)
+
+// LabelPair is a single name/value label.
+type LabelPair struct {
+	Name  string
+	Value string
+}
+
+// Timestamp is a google.protobuf.Timestamp. The zero value means "not set"
+// and is not written.
+type Timestamp struct {
+	Seconds int64
+	Nanos   int32
+}
+
+// IsZero reports whether the timestamp is unset.
+func (t Timestamp) IsZero() bool {
+	return t.Seconds == 0 && t.Nanos == 0
+}
+
+// Exemplar is a single exemplar attached to a counter or histogram bucket.
+type Exemplar struct {
+	Label     []LabelPair
+	Value     float64
+	Timestamp Timestamp
+}
+
+// Gauge holds the value of a gauge metric.
+type Gauge struct {
+	Value float64
+}
+
+// Counter holds the value of a counter metric.
+type Counter struct {
+	Value            float64
+	Exemplar         Exemplar
+	HasExemplar      bool
+	CreatedTimestamp Timestamp
+}
+
+// Quantile is a single quantile of a summary.
+type Quantile struct {
+	Quantile float64
+	Value    float64
+}
+
+// Summary holds the value of a summary metric.
+type Summary struct {
+	SampleCount      uint64
+	SampleSum        float64
+	Quantile         []Quantile
+	CreatedTimestamp Timestamp
+}
+
+// Untyped holds the value of an untyped metric.
+type Untyped struct {
+	Value float64
+}
+
+// Bucket is a single bucket of a classic histogram.
+type Bucket struct {
+	CumulativeCount      uint64
+	CumulativeCountFloat float64
+	UpperBound           float64
+	Exemplar             Exemplar
+	HasExemplar          bool
+}
+
+// BucketSpan describes a run of consecutive native histogram buckets.
+type BucketSpan struct {
+	Offset int32
+	Length uint32
+}
+
+// Histogram holds the value of a classic and/or native histogram.
+//
+// Native is set by the reader when any native histogram field (schema,
+// zero bucket, spans, deltas or counts) is present, and tells the writer to
+// emit those fields. Schema zero is a valid native schema, so presence
+// cannot be inferred from the values alone.
+type Histogram struct {
+	SampleCount      uint64
+	SampleCountFloat float64
+	SampleSum        float64
+	Bucket           []Bucket
+	CreatedTimestamp Timestamp
+
+	Native         bool
+	Schema         int32
+	ZeroThreshold  float64
+	ZeroCount      uint64
+	ZeroCountFloat float64
+	NegativeSpan   []BucketSpan
+	NegativeDelta  []int64
+	NegativeCount  []float64
+	PositiveSpan   []BucketSpan
+	PositiveDelta  []int64
+	PositiveCount  []float64
+
+	Exemplars []Exemplar
+}
+
+// Metric is a single sample set of a family. Which of Gauge, Counter,
+// Summary, Untyped or Histogram is meaningful follows from the family Type.
+// TimestampMs zero means "not set".
+type Metric struct {
+	Label       []LabelPair
+	Gauge       Gauge
+	Counter     Counter
+	Summary     Summary
+	Untyped     Untyped
+	Histogram   Histogram
+	TimestampMs int64
+}
+
+// MetricFamily is a named group of metrics of the same type.
+type MetricFamily struct {
+	Name   string
+	Help   string
+	Type   MetricType
+	Unit   string
+	Metric []Metric
+}
+
+// Reset clears the family while keeping allocated slices for reuse.
+func (mf *MetricFamily) Reset() {
+	mf.Name = ""
+	mf.Help = ""
+	mf.Type = MetricTypeCounter
+	mf.Unit = ""
+	mf.Metric = mf.Metric[:0]
+}
+
+// next appends a metric to the family, recycling a previously used element
+// (and its slices) when capacity allows.
+func (mf *MetricFamily) next() *Metric {
+	m := grow(&mf.Metric)
+	m.reset()
+	return m
+}
+
+func (m *Metric) reset() {
+	m.Label = m.Label[:0]
+	m.Gauge = Gauge{}
+	m.Counter.Value = 0
+	m.Counter.Exemplar.reset()
+	m.Counter.HasExemplar = false
+	m.Counter.CreatedTimestamp = Timestamp{}
+	m.Summary = Summary{Quantile: m.Summary.Quantile[:0]}
+	m.Untyped = Untyped{}
+	m.Histogram.reset()
+	m.TimestampMs = 0
+}
+
+func (e *Exemplar) reset() {
+	e.Label = e.Label[:0]
+	e.Value = 0
+	e.Timestamp = Timestamp{}
+}
+
+func (h *Histogram) reset() {
+	*h = Histogram{
+		Bucket:        h.Bucket[:0],
+		NegativeSpan:  h.NegativeSpan[:0],
+		NegativeDelta: h.NegativeDelta[:0],
+		NegativeCount: h.NegativeCount[:0],
+		PositiveSpan:  h.PositiveSpan[:0],
+		PositiveDelta: h.PositiveDelta[:0],
+		PositiveCount: h.PositiveCount[:0],
+		Exemplars:     h.Exemplars[:0],
+	}
+}
+
+// grow extends s by one element, recycling the backing array when possible,
+// and returns a pointer to the new element. The element keeps whatever
+// nested slices it held before so callers can truncate and reuse them.
+func grow[T any](s *[]T) *T {
+	if len(*s) < cap(*s) {
+		*s = (*s)[:len(*s)+1]
+	} else {
+		var zero T
+		*s = append(*s, zero)
+	}
+	return &(*s)[len(*s)-1]
+}
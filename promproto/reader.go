@@ -0,0 +1,135 @@
+package promproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/lomik/rawpb"
+)
+
+// DefaultMaxSize is the default limit on a single delimited MetricFamily
+// message accepted by Reader.
+const DefaultMaxSize = 32 << 20
+
+// Reader iterates a varint-delimited stream of MetricFamily messages.
+//
+//	r := promproto.NewReader(resp.Body)
+//	for r.Next() {
+//	    mf := r.MetricFamily()
+//	    ...
+//	}
+//	if err := r.Err(); err != nil { return err }
+//
+// The message buffer and the MetricFamily are reused across Next calls:
+// the family returned by MetricFamily, including every string in it, is
+// only valid until the next call to Next.
+type Reader struct {
+	r       *bufio.Reader
+	buf     []byte
+	mf      MetricFamily
+	maxSize uint64
+	err     error
+}
+
+// NewReader returns a Reader over r. If r is already a *bufio.Reader it is
+// used directly.
+func NewReader(r io.Reader) *Reader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Reader{
+		r:       br,
+		maxSize: DefaultMaxSize,
+	}
+}
+
+// SetMaxSize limits the size of a single MetricFamily message. Zero means
+// unlimited. Larger messages make Next fail with rawpb.ErrorTooLarge.
+func (r *Reader) SetMaxSize(size uint64) {
+	r.maxSize = size
+}
+
+// Next reads and decodes the next MetricFamily. It returns false at the end
+// of the stream or on error (see Err).
+func (r *Reader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	l, err := readLength(r.r)
+	if err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+		return false
+	}
+	if r.maxSize > 0 && l > r.maxSize {
+		r.err = rawpb.ErrorTooLarge
+		return false
+	}
+
+	if r.buf, err = readMessage(r.r, r.buf, l); err != nil {
+		r.err = fmt.Errorf("%w: %w", rawpb.ErrorTruncated, err)
+		return false
+	}
+
+	if err = Decode(r.buf, &r.mf); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+// MetricFamily returns the family decoded by the last successful Next.
+func (r *Reader) MetricFamily() *MetricFamily {
+	return &r.mf
+}
+
+// Err returns the first error encountered by Next, or nil at a clean end of
+// stream.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// readMessage reads the l-byte message body into buf, reusing its capacity.
+// Beyond that capacity buf grows only as bytes arrive, so a length prefix
+// larger than the stream (possible with SetMaxSize(0)) cannot allocate more
+// than was actually sent.
+func readMessage(r io.Reader, buf []byte, l uint64) ([]byte, error) {
+	buf = buf[:0]
+	for uint64(len(buf)) < l {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		end := min(uint64(cap(buf)), l)
+		n, err := io.ReadFull(r, buf[len(buf):end])
+		buf = buf[:len(buf)+n]
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// readLength reads the varint length prefix of the next message. It returns
+// io.EOF only when the stream ends cleanly before the first byte.
+func readLength(r io.ByteReader) (uint64, error) {
+	var v uint64
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if i == 0 && err == io.EOF {
+				return 0, io.EOF
+			}
+			return 0, fmt.Errorf("%w: %w", rawpb.ErrorTruncated, err)
+		}
+		v |= uint64(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, rawpb.ErrorInvalidMessage
+}
@@ -0,0 +1,216 @@
+package promproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/lomik/rawpb"
+)
+
+// Writer emits a varint-delimited stream of MetricFamily messages. Its
+// encoding buffer is reused across calls.
+type Writer struct {
+	out io.Writer
+	buf bytes.Buffer
+	w   *rawpb.Writer
+	hdr [binary.MaxVarintLen64]byte
+}
+
+// NewWriter returns a Writer that writes to out.
+func NewWriter(out io.Writer) *Writer {
+	w := &Writer{out: out}
+	w.w = rawpb.NewWriter(&w.buf)
+	return w
+}
+
+// Write encodes mf and writes it to the underlying stream prefixed with its
+// length.
+func (w *Writer) Write(mf *MetricFamily) error {
+	w.buf.Reset()
+	Encode(w.w, mf)
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+
+	n := binary.PutUvarint(w.hdr[:], uint64(w.buf.Len()))
+	if _, err := w.out.Write(w.hdr[:n]); err != nil {
+		return err
+	}
+	_, err := w.out.Write(w.buf.Bytes())
+	return err
+}
+
+// Encode writes the fields of mf (without a length prefix) to w.
+func Encode(w *rawpb.Writer, mf *MetricFamily) {
+	if mf.Name != "" {
+		w.String(1, mf.Name)
+	}
+	if mf.Help != "" {
+		w.String(2, mf.Help)
+	}
+	w.Enum(3, int32(mf.Type))
+	for i := range mf.Metric {
+		m := &mf.Metric[i]
+		w.Message(4, func(w *rawpb.Writer) error {
+			encodeMetric(w, mf.Type, m)
+			return nil
+		})
+	}
+	if mf.Unit != "" {
+		w.String(5, mf.Unit)
+	}
+}
+
+func encodeMetric(w *rawpb.Writer, tp MetricType, m *Metric) {
+	for i := range m.Label {
+		encodeLabel(w, 1, &m.Label[i])
+	}
+
+	switch tp {
+	case MetricTypeGauge:
+		w.Message(2, func(w *rawpb.Writer) error {
+			w.Double(1, m.Gauge.Value)
+			return nil
+		})
+	case MetricTypeCounter:
+		w.Message(3, func(w *rawpb.Writer) error {
+			c := &m.Counter
+			w.Double(1, c.Value)
+			if c.HasExemplar {
+				encodeExemplar(w, 2, &c.Exemplar)
+			}
+			encodeTimestamp(w, 3, c.CreatedTimestamp)
+			return nil
+		})
+	case MetricTypeSummary:
+		w.Message(4, func(w *rawpb.Writer) error {
+			s := &m.Summary
+			w.Uint64(1, s.SampleCount)
+			w.Double(2, s.SampleSum)
+			for i := range s.Quantile {
+				q := &s.Quantile[i]
+				w.Message(3, func(w *rawpb.Writer) error {
+					w.Double(1, q.Quantile)
+					w.Double(2, q.Value)
+					return nil
+				})
+			}
+			encodeTimestamp(w, 4, s.CreatedTimestamp)
+			return nil
+		})
+	case MetricTypeUntyped:
+		w.Message(5, func(w *rawpb.Writer) error {
+			w.Double(1, m.Untyped.Value)
+			return nil
+		})
+	case MetricTypeHistogram, MetricTypeGaugeHistogram:
+		w.Message(7, func(w *rawpb.Writer) error {
+			encodeHistogram(w, &m.Histogram)
+			return nil
+		})
+	}
+
+	if m.TimestampMs != 0 {
+		w.Int64(6, m.TimestampMs)
+	}
+}
+
+func encodeLabel(w *rawpb.Writer, num int, l *LabelPair) {
+	w.Message(num, func(w *rawpb.Writer) error {
+		w.String(1, l.Name)
+		w.String(2, l.Value)
+		return nil
+	})
+}
+
+func encodeTimestamp(w *rawpb.Writer, num int, t Timestamp) {
+	if t.IsZero() {
+		return
+	}
+	w.Message(num, func(w *rawpb.Writer) error {
+		if t.Seconds != 0 {
+			w.Int64(1, t.Seconds)
+		}
+		if t.Nanos != 0 {
+			w.Int32(2, t.Nanos)
+		}
+		return nil
+	})
+}
+
+func encodeExemplar(w *rawpb.Writer, num int, e *Exemplar) {
+	w.Message(num, func(w *rawpb.Writer) error {
+		for i := range e.Label {
+			encodeLabel(w, 1, &e.Label[i])
+		}
+		w.Double(2, e.Value)
+		encodeTimestamp(w, 3, e.Timestamp)
+		return nil
+	})
+}
+
+func encodeSpans(w *rawpb.Writer, num int, spans []BucketSpan) {
+	for i := range spans {
+		s := &spans[i]
+		w.Message(num, func(w *rawpb.Writer) error {
+			w.Sint32(1, s.Offset)
+			w.Uint32(2, s.Length)
+			return nil
+		})
+	}
+}
+
+func encodeHistogram(w *rawpb.Writer, h *Histogram) {
+	w.Uint64(1, h.SampleCount)
+	w.Double(2, h.SampleSum)
+	for i := range h.Bucket {
+		b := &h.Bucket[i]
+		w.Message(3, func(w *rawpb.Writer) error {
+			if b.CumulativeCountFloat != 0 {
+				w.Double(4, b.CumulativeCountFloat)
+			} else {
+				w.Uint64(1, b.CumulativeCount)
+			}
+			w.Double(2, b.UpperBound)
+			if b.HasExemplar {
+				encodeExemplar(w, 3, &b.Exemplar)
+			}
+			return nil
+		})
+	}
+	if h.SampleCountFloat != 0 {
+		w.Double(4, h.SampleCountFloat)
+	}
+
+	if h.Native {
+		w.Sint32(5, h.Schema)
+		w.Double(6, h.ZeroThreshold)
+		if h.ZeroCountFloat != 0 {
+			w.Double(8, h.ZeroCountFloat)
+		} else {
+			w.Uint64(7, h.ZeroCount)
+		}
+		encodeSpans(w, 9, h.NegativeSpan)
+		// client_model declares these as proto2 repeated fields without
+		// [packed=true], so they are written unpacked.
+		for _, v := range h.NegativeDelta {
+			w.Sint64(10, v)
+		}
+		for _, v := range h.NegativeCount {
+			w.Double(11, v)
+		}
+		encodeSpans(w, 12, h.PositiveSpan)
+		for _, v := range h.PositiveDelta {
+			w.Sint64(13, v)
+		}
+		for _, v := range h.PositiveCount {
+			w.Double(14, v)
+		}
+	}
+
+	encodeTimestamp(w, 15, h.CreatedTimestamp)
+	for i := range h.Exemplars {
+		encodeExemplar(w, 16, &h.Exemplars[i])
+	}
+}
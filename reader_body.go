@@ -1,45 +1,79 @@
 package rawpb
 
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
 // maxVarintBytes is the maximum length of a valid protobuf varint for a 64-bit
 // value. Anything longer is treated as a malformed message.
 const maxVarintBytes = 10
 
+// readerBodyPool recycles the source of Parse. Method calls through the
+// generic engine make the compiler move it to the heap, and Parse is
+// expected to run without allocating.
+var readerBodyPool = sync.Pool{
+	New: func() any { return new(readerBody) },
+}
+
+// bytesReaderPool holds the readers Parse hands to BytesReader callbacks,
+// so that passing one to a callback does not allocate.
+var bytesReaderPool = sync.Pool{
+	New: func() any { return new(bytes.Reader) },
+}
+
+// readerBody is the wire source of Parse and the cursor of Decoder.
+// Length-delimited values are returned as subslices of body, without
+// copying.
 type readerBody struct {
 	body   []byte
 	offset int
+	end    int // end of the current message or packed payload
+	start  int // offset of the last tag read
 }
 
-func newReaderBody(body []byte) *readerBody {
-	return &readerBody{
+func newReaderBody(body []byte) readerBody {
+	return readerBody{
 		body: body,
+		end:  len(body),
 	}
 }
 
+func (r *readerBody) tag() (uint64, bool, error) {
+	if r.offset >= r.end {
+		return 0, false, nil
+	}
+	r.start = r.offset
+	v, err := r.varint()
+	return v, true, err
+}
+
+// varint decodes in place rather than calling decodeVarint, so that it
+// inlines into the parse loops.
 func (r *readerBody) varint() (uint64, error) {
-	var ret uint64
-	i := uint64(0)
-	for r.next() {
-		if i >= maxVarintBytes {
-			return 0, ErrorInvalidMessage
-		}
-		ret += uint64(r.body[r.offset]&0x7f) << (7 * i)
-		if r.body[r.offset]&0x80 == 0 { // last byte of varint
-			r.offset++
-			return ret, nil
+	var v uint64
+	for i := 0; i < maxVarintBytes; i++ {
+		if r.offset >= r.end {
+			return 0, ErrorTruncated
 		}
+		b := r.body[r.offset]
 		r.offset++
-		i++
+		v |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return v, nil
+		}
 	}
-	return ret, ErrorTruncated
+	return 0, ErrorInvalidMessage
 }
 
-func (r *readerBody) next() bool {
-	return r.offset < len(r.body)
+func (r *readerBody) more() bool {
+	return r.offset < r.end
 }
 
 func (r *readerBody) bytes(n uint64) ([]byte, error) {
-	avail := uint64(len(r.body) - r.offset)
-	if n > avail {
+	if n > uint64(r.end-r.offset) {
 		return nil, ErrorTruncated
 	}
 	end := r.offset + int(n)
@@ -48,29 +82,60 @@ func (r *readerBody) bytes(n uint64) ([]byte, error) {
 	return v, nil
 }
 
-func (r *readerBody) lengthDelimited() ([]byte, error) {
-	l, err := r.varint()
+func (r *readerBody) reader(n uint64, f func(io.Reader, uint64) error) error {
+	v, err := r.bytes(n)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return r.bytes(l)
+	br := bytesReaderPool.Get().(*bytes.Reader)
+	br.Reset(v)
+	err = f(br, n)
+	br.Reset(nil)
+	bytesReaderPool.Put(br)
+	return err
+}
+
+func (r *readerBody) skip(n uint64) error {
+	_, err := r.bytes(n)
+	return err
+}
+
+func (r *readerBody) push(n uint64) (uint64, error) {
+	if n > uint64(r.end-r.offset) {
+		return 0, ErrorTruncated
+	}
+	saved := r.end
+	r.end = r.offset + int(n)
+	return uint64(saved), nil
+}
+
+func (r *readerBody) pop(saved uint64) {
+	r.end = int(saved)
 }
 
 func (r *readerBody) fixed64() (uint64, error) {
-	p, err := r.bytes(8)
-	if err != nil {
-		return 0, err
+	if r.end-r.offset < 8 {
+		return 0, ErrorTruncated
 	}
-	u := uint64(p[0]) | (uint64(p[1]) << 8) | (uint64(p[2]) << 16) | (uint64(p[3]) << 24) |
-		(uint64(p[4]) << 32) | (uint64(p[5]) << 40) | (uint64(p[6]) << 48) | (uint64(p[7]) << 56)
-	return u, nil
+	v := binary.LittleEndian.Uint64(r.body[r.offset:])
+	r.offset += 8
+	return v, nil
 }
 
 func (r *readerBody) fixed32() (uint32, error) {
-	p, err := r.bytes(4)
+	if r.end-r.offset < 4 {
+		return 0, ErrorTruncated
+	}
+	v := binary.LittleEndian.Uint32(r.body[r.offset:])
+	r.offset += 4
+	return v, nil
+}
+
+func (r *readerBody) raw(wt int) ([]byte, error) {
+	n, err := valueSize(r.body[r.offset:r.end], wt)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	u := uint32(p[0]) | (uint32(p[1]) << 8) | (uint32(p[2]) << 16) | (uint32(p[3]) << 24)
-	return u, nil
+	r.offset += n
+	return r.body[r.start:r.offset], nil
 }
@@ -22,22 +22,64 @@ type Reader interface {
 	io.ByteScanner
 }
 
+// readerLimit is the wireSource of Read. limit is the number of bytes left
+// in the current message; length-delimited values are copied out of the
+// stream into memory from mem.
 type readerLimit struct {
 	w     Reader
 	mem   Allocator
 	buf   [10]byte
 	limit uint64
+	read  uint64           // total bytes consumed from w
+	max   uint64           // MaxSize, the cap on read; zero means none
+	lr    io.LimitedReader // for skip, kept here so skipping does not allocate
+	fr    fieldReader      // for reader
+
+	// encoded form of the last tag, for raw
+	tagBuf [maxVarintBytes]byte
+	tagLen int
 }
 
-func newReaderLimit(w Reader, mem Allocator, limit uint64) *readerLimit {
+// newReaderLimit returns the source of a message that spans all of w, up
+// to max bytes when max is not zero.
+func newReaderLimit(w Reader, mem Allocator, max uint64) *readerLimit {
+	limit := max
+	if limit == 0 {
+		limit = math.MaxUint64
+	}
 	return &readerLimit{
 		w:     w,
 		mem:   mem,
 		limit: limit,
+		max:   max,
+	}
+}
+
+// short is the error for a value that needs n more bytes than the current
+// message holds: ErrorTooLarge if they would take the stream past max,
+// ErrorTruncated otherwise.
+func (r *readerLimit) short(n uint64) error {
+	if r.max > 0 && n > r.max-r.read {
+		return ErrorTooLarge
+	}
+	return ErrorTruncated
+}
+
+// full reports whether the stream goes on past max, once max bytes were
+// read. It peeks at one byte and unreads it, so nothing past max is
+// consumed.
+func (r *readerLimit) full() bool {
+	if r.max == 0 || r.read < r.max {
+		return false
+	}
+	if _, err := r.w.ReadByte(); err != nil {
+		return false
 	}
+	r.w.UnreadByte()
+	return true
 }
 
-func (r *readerLimit) varintOrBreak() (uint64, bool, error) {
+func (r *readerLimit) tag() (uint64, bool, error) {
 	var ret uint64
 	var b byte
 	var err error
@@ -48,23 +90,30 @@ func (r *readerLimit) varintOrBreak() (uint64, bool, error) {
 		}
 		if r.limit == 0 {
 			if i == 0 {
-				// can't read first byte. stream ended
-				return 0, true, nil
+				// can't read first byte. message ended, unless the
+				// stream holds more than max
+				if r.full() {
+					return 0, true, ErrorTooLarge
+				}
+				return 0, false, nil
 			}
-			return ret, true, ErrorTruncated
+			return ret, true, r.short(1)
 		}
 		b, err = r.w.ReadByte()
 		if err != nil {
 			if i == 0 && err == io.EOF {
 				// can't read first byte. stream ended
-				return 0, true, nil
+				return 0, false, nil
 			}
 			return ret, true, truncated(err)
 		}
 		r.limit--
+		r.read++
+		r.tagBuf[i] = b
 		ret += uint64(b&0x7f) << (7 * i)
 		if b&0x80 == 0 { // last byte of varint
-			return ret, false, nil
+			r.tagLen = int(i) + 1
+			return ret, true, nil
 		}
 		i++
 	}
@@ -80,13 +129,14 @@ func (r *readerLimit) varint() (uint64, error) {
 			return ret, ErrorInvalidMessage
 		}
 		if r.limit == 0 {
-			return ret, ErrorTruncated
+			return ret, r.short(1)
 		}
 		b, err = r.w.ReadByte()
 		if err != nil {
 			return ret, truncated(err)
 		}
 		r.limit--
+		r.read++
 		ret += uint64(b&0x7f) << (7 * i)
 		if b&0x80 == 0 { // last byte of varint
 			return ret, nil
@@ -95,45 +145,72 @@ func (r *readerLimit) varint() (uint64, error) {
 	}
 }
 
-func (r *readerLimit) next() bool {
-	if r.limit == 0 {
-		return false
-	}
-	_, err := r.w.ReadByte()
-	if err != nil {
-		return false
-	}
-	if r.w.UnreadByte() != nil {
-		return false
+func (r *readerLimit) more() bool {
+	return r.limit > 0
+}
+
+func (r *readerLimit) push(n uint64) (uint64, error) {
+	if n > r.limit {
+		return 0, r.short(n)
 	}
-	return true
+	saved := r.limit - n
+	r.limit = n
+	return saved, nil
+}
+
+func (r *readerLimit) pop(saved uint64) {
+	r.limit = saved
 }
 
 func (r *readerLimit) skip(n uint64) error {
 	if n > r.limit {
-		return ErrorTruncated
+		return r.short(n)
 	}
 	if n > uint64(math.MaxInt64) {
 		return ErrorInvalidMessage
 	}
-	_, err := io.CopyN(io.Discard, r.w, int64(n))
+	r.lr = io.LimitedReader{R: r.w, N: int64(n)}
+	c, err := io.Copy(io.Discard, &r.lr)
+	r.lr.R = nil
 	r.limit -= n
+	r.read += uint64(c)
+	if err == nil && uint64(c) < n {
+		err = io.EOF
+	}
 	if err != nil {
 		return truncated(err)
 	}
 	return nil
 }
 
+// reader streams the next n bytes to f. The limit check comes first, so a
+// payload crossing MaxSize fails before f runs.
+func (r *readerLimit) reader(n uint64, f func(io.Reader, uint64) error) error {
+	if n > r.limit {
+		return r.short(n)
+	}
+	start := r.read
+	r.fr = fieldReader{r: r, end: start + n}
+	err := f(&r.fr, n)
+	r.fr.end = 0 // a reader kept by f only returns io.EOF
+	r.limit -= r.read - start
+	if err != nil {
+		return err
+	}
+	return r.skip(start + n - r.read)
+}
+
 func (r *readerLimit) bytes(n uint64) ([]byte, error) {
 	if n > r.limit {
-		return nil, ErrorTruncated
+		return nil, r.short(n)
 	}
 	if n > uint64(math.MaxInt) {
 		return nil, ErrorInvalidMessage
 	}
 	p := r.mem.Alloc(int(n))
 
-	_, err := io.ReadAtLeast(r.w, p, int(n))
+	c, err := io.ReadAtLeast(r.w, p, int(n))
+	r.read += uint64(c)
 	if err != nil {
 		return p, truncated(err)
 	}
@@ -143,28 +220,93 @@ func (r *readerLimit) bytes(n uint64) ([]byte, error) {
 
 func (r *readerLimit) fixed64() (uint64, error) {
 	if r.limit < 8 {
-		return 0, ErrorTruncated
+		return 0, r.short(8)
 	}
 	_, err := io.ReadAtLeast(r.w, r.buf[:8], 8)
 	if err != nil {
 		return 0, truncated(err)
 	}
-
-	u := uint64(r.buf[0]) | (uint64(r.buf[1]) << 8) | (uint64(r.buf[2]) << 16) | (uint64(r.buf[3]) << 24) |
-		(uint64(r.buf[4]) << 32) | (uint64(r.buf[5]) << 40) | (uint64(r.buf[6]) << 48) | (uint64(r.buf[7]) << 56)
+	u, _ := decodeFixed64(r.buf[:8])
 	r.limit -= 8
+	r.read += 8
 	return u, nil
 }
 
 func (r *readerLimit) fixed32() (uint32, error) {
 	if r.limit < 4 {
-		return 0, ErrorTruncated
+		return 0, r.short(4)
 	}
 	_, err := io.ReadAtLeast(r.w, r.buf[:4], 4)
 	if err != nil {
 		return 0, truncated(err)
 	}
-	u := uint32(r.buf[0]) | (uint32(r.buf[1]) << 8) | (uint32(r.buf[2]) << 16) | (uint32(r.buf[3]) << 24)
+	u, _ := decodeFixed32(r.buf[:4])
 	r.limit -= 4
+	r.read += 4
 	return u, nil
 }
+
+// raw copies the tag and value of the current field into one allocation.
+// The tag was already consumed by tag, which kept its encoded bytes, so
+// the result matches the input byte for byte.
+func (r *readerLimit) raw(wt int) ([]byte, error) {
+	var hdr [2 * maxVarintBytes]byte
+	h := append(hdr[:0], r.tagBuf[:r.tagLen]...)
+
+	var n uint64 // value bytes still to read from the stream
+	switch wt {
+	case WireVarint, WireLen:
+		var v uint64
+		var err error
+		if h, v, err = r.appendVarint(h); err != nil {
+			return nil, err
+		}
+		if wt == WireLen {
+			n = v
+		}
+	case WireFixed64:
+		n = 8
+	case WireFixed32:
+		n = 4
+	}
+	if n > r.limit {
+		return nil, r.short(n)
+	}
+	if n > uint64(math.MaxInt-len(h)) {
+		return nil, ErrorInvalidMessage
+	}
+
+	p := r.mem.Alloc(len(h) + int(n))
+	copy(p, h)
+	c, err := io.ReadFull(r.w, p[len(h):])
+	r.read += uint64(c)
+	if err != nil {
+		return p, truncated(err)
+	}
+	r.limit -= n
+	return p, nil
+}
+
+// appendVarint is varint that also appends the encoded bytes to dst.
+func (r *readerLimit) appendVarint(dst []byte) ([]byte, uint64, error) {
+	var ret uint64
+	for i := 0; ; i++ {
+		if i >= maxVarintBytes {
+			return dst, ret, ErrorInvalidMessage
+		}
+		if r.limit == 0 {
+			return dst, ret, r.short(1)
+		}
+		b, err := r.w.ReadByte()
+		if err != nil {
+			return dst, ret, truncated(err)
+		}
+		r.limit--
+		r.read++
+		dst = append(dst, b)
+		ret |= uint64(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return dst, ret, nil
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package rawpb
+
+import "math/bits"
+
+// SizeVarint returns the number of bytes AppendVarint uses for v.
+func SizeVarint(v uint64) int {
+	// 1 + (bits-1)/7 without a branch for v == 0
+	return int(9*uint32(bits.Len64(v))+64) / 64
+}
+
+// SizeTag returns the number of bytes AppendTag uses for field num.
+func SizeTag(num int) int {
+	return SizeVarint(uint64(num) << 3)
+}
+
+// SizeBytes returns the size of a length-delimited value of n bytes,
+// length prefix included, as AppendBytes writes it.
+func SizeBytes(n int) int {
+	return SizeVarint(uint64(n)) + n
+}
+
+// sizer counts the bytes a Writer run by Size would write. Its methods
+// mirror the Encoder methods Writer calls, so both stay in agreement.
+type sizer struct {
+	n int
+}
+
+// Size runs cb on a Writer that only counts bytes and returns the size of
+// what it would have written, or the error of cb. Pass the options of the
+// Writer being sized.
+func Size(cb func(w *Writer) error, opts ...WriterOption) (int, error) {
+	w := getWriter(nil, opts)
+	defer putWriter(w)
+	w.sizing = true
+
+	err := cb(w)
+	if err == nil {
+		err = w.err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return w.sizer.n, nil
+}
+
+// Bytes counts a length-delimited byte slice field
+func (s *sizer) Bytes(num int, v []byte) {
+	s.n += SizeTag(num) + SizeBytes(len(v))
+}
+
+// Raw counts already encoded fields
+func (s *sizer) Raw(encoded []byte) {
+	s.n += len(encoded)
+}
+
+// Fixed64 counts a 64-bit fixed-size field
+func (s *sizer) Fixed64(num int, v uint64) {
+	s.n += SizeTag(num) + 8
+}
+
+// Fixed32 counts a 32-bit fixed-size field
+func (s *sizer) Fixed32(num int, v uint32) {
+	s.n += SizeTag(num) + 4
+}
+
+// Uint64 counts an unsigned 64-bit integer field
+func (s *sizer) Uint64(num int, v uint64) {
+	s.n += SizeTag(num) + SizeVarint(v)
+}
@@ -0,0 +1,194 @@
+// Copyright 2011 The Snappy-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// Derived from github.com/golang/snappy.
+
+package snappy
+
+import (
+	"bufio"
+	"io"
+)
+
+// BlockReader decompresses a snappy block lazily, one tag at a time, as its
+// output is read. It implements io.Reader and io.ByteScanner and therefore
+// rawpb.Reader.
+//
+// Decoded bytes are kept in an internal buffer, because later copy tags may
+// reference any earlier output, but that buffer only grows as far as the
+// consumer actually reads. When the consumer stops early (for example
+// because the parser hit its MaxSize), the rest of the block is never
+// inflated. Reset rebinds the reader to a new block and keeps the buffer.
+type BlockReader struct {
+	src  []byte
+	s    int    // read position in src
+	out  []byte // decoded so far
+	r    int    // read position in out
+	dLen int    // declared decoded length
+	err  error
+}
+
+// NewBlockReader returns a BlockReader over the snappy block src.
+func NewBlockReader(src []byte) *BlockReader {
+	r := &BlockReader{}
+	r.Reset(src)
+	return r
+}
+
+// Reset rebinds the reader to a new block, keeping the decode buffer.
+func (r *BlockReader) Reset(src []byte) {
+	r.src = src
+	r.out = r.out[:0]
+	r.r = 0
+	r.err = nil
+	r.dLen, r.s, r.err = decodedLen(src)
+}
+
+// DecodedLen returns the decoded length declared in the block preamble.
+func (r *BlockReader) DecodedLen() int {
+	return r.dLen
+}
+
+// fill decodes tags until unread output is available, the block ends or an
+// error occurs.
+func (r *BlockReader) fill() error {
+	for r.r >= len(r.out) {
+		if r.err != nil {
+			return r.err
+		}
+		if r.s >= len(r.src) {
+			if len(r.out) != r.dLen {
+				r.err = ErrCorrupt
+			} else {
+				r.err = io.EOF
+			}
+			return r.err
+		}
+		if err := r.step(); err != nil {
+			r.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// step decodes a single literal or copy tag onto out.
+func (r *BlockReader) step() error {
+	src := r.src
+	s := r.s
+	var offset, length int
+
+	switch src[s] & 0x03 {
+	case tagLiteral:
+		x := uint32(src[s] >> 2)
+		switch {
+		case x < 60:
+			s++
+		case x == 60:
+			s += 2
+			if s > len(src) {
+				return ErrCorrupt
+			}
+			x = uint32(src[s-1])
+		case x == 61:
+			s += 3
+			if s > len(src) {
+				return ErrCorrupt
+			}
+			x = uint32(src[s-2]) | uint32(src[s-1])<<8
+		case x == 62:
+			s += 4
+			if s > len(src) {
+				return ErrCorrupt
+			}
+			x = uint32(src[s-3]) | uint32(src[s-2])<<8 | uint32(src[s-1])<<16
+		case x == 63:
+			s += 5
+			if s > len(src) {
+				return ErrCorrupt
+			}
+			x = uint32(src[s-4]) | uint32(src[s-3])<<8 | uint32(src[s-2])<<16 | uint32(src[s-1])<<24
+		}
+		length = int(x) + 1
+		if length <= 0 || length > r.dLen-len(r.out) || length > len(src)-s {
+			return ErrCorrupt
+		}
+		r.out = append(r.out, src[s:s+length]...)
+		r.s = s + length
+		return nil
+
+	case tagCopy1:
+		s += 2
+		if s > len(src) {
+			return ErrCorrupt
+		}
+		length = 4 + int(src[s-2])>>2&0x7
+		offset = int(uint32(src[s-2])&0xe0<<3 | uint32(src[s-1]))
+
+	case tagCopy2:
+		s += 3
+		if s > len(src) {
+			return ErrCorrupt
+		}
+		length = 1 + int(src[s-3])>>2
+		offset = int(uint32(src[s-2]) | uint32(src[s-1])<<8)
+
+	case tagCopy4:
+		s += 5
+		if s > len(src) {
+			return ErrCorrupt
+		}
+		length = 1 + int(src[s-5])>>2
+		offset = int(uint32(src[s-4]) | uint32(src[s-3])<<8 | uint32(src[s-2])<<16 | uint32(src[s-1])<<24)
+	}
+
+	d := len(r.out)
+	if offset <= 0 || d < offset || length > r.dLen-d {
+		return ErrCorrupt
+	}
+	for i := 0; i < length; i++ {
+		r.out = append(r.out, r.out[d+i-offset])
+	}
+	r.s = s
+	return nil
+}
+
+// Read implements io.Reader.
+func (r *BlockReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n := 0
+	for n < len(p) {
+		if err := r.fill(); err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		c := copy(p[n:], r.out[r.r:])
+		r.r += c
+		n += c
+	}
+	return n, nil
+}
+
+// ReadByte implements io.ByteReader.
+func (r *BlockReader) ReadByte() (byte, error) {
+	if err := r.fill(); err != nil {
+		return 0, err
+	}
+	b := r.out[r.r]
+	r.r++
+	return b, nil
+}
+
+// UnreadByte implements io.ByteScanner.
+func (r *BlockReader) UnreadByte() error {
+	if r.r == 0 {
+		return bufio.ErrInvalidUnreadByte
+	}
+	r.r--
+	return nil
+}
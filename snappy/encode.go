@@ -0,0 +1,179 @@
+// Copyright 2011 The Snappy-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// Derived from github.com/golang/snappy.
+
+package snappy
+
+import (
+	"encoding/binary"
+)
+
+// MaxEncodedLen returns the maximum length of a snappy block, given its
+// uncompressed length.
+func MaxEncodedLen(srcLen int) int {
+	return 32 + srcLen + srcLen/6
+}
+
+// Encode returns the block-format encoding of src. The returned slice may
+// be a sub-slice of dst if dst was large enough to hold the entire encoded
+// block.
+func Encode(dst, src []byte) []byte {
+	if n := MaxEncodedLen(len(src)); cap(dst) < n {
+		dst = make([]byte, n)
+	} else {
+		dst = dst[:n]
+	}
+
+	d := binary.PutUvarint(dst, uint64(len(src)))
+	for len(src) > 0 {
+		p := src
+		if len(p) > maxBlockSize {
+			p = p[:maxBlockSize]
+		}
+		src = src[len(p):]
+		if len(p) < minNonLiteralBlockSize {
+			d += emitLiteral(dst[d:], p)
+		} else {
+			d += encodeBlock(dst[d:], p)
+		}
+	}
+	return dst[:d]
+}
+
+func load32(b []byte, i int) uint32 {
+	return binary.LittleEndian.Uint32(b[i:])
+}
+
+func load64(b []byte, i int) uint64 {
+	return binary.LittleEndian.Uint64(b[i:])
+}
+
+func hash(u uint32, shift uint) uint32 {
+	return (u * 0x1e35a7bd) >> shift
+}
+
+// emitLiteral writes a literal chunk and returns the number of bytes
+// written. It assumes 1 <= len(lit) <= 65536.
+func emitLiteral(dst, lit []byte) int {
+	i, n := 0, uint(len(lit)-1)
+	switch {
+	case n < 60:
+		dst[0] = uint8(n)<<2 | tagLiteral
+		i = 1
+	case n < 1<<8:
+		dst[0] = 60<<2 | tagLiteral
+		dst[1] = uint8(n)
+		i = 2
+	default:
+		dst[0] = 61<<2 | tagLiteral
+		dst[1] = uint8(n)
+		dst[2] = uint8(n >> 8)
+		i = 3
+	}
+	return i + copy(dst[i:], lit)
+}
+
+// emitCopy writes a copy chunk and returns the number of bytes written. It
+// assumes 1 <= offset <= 65535 and length >= 4.
+func emitCopy(dst []byte, offset, length int) int {
+	i := 0
+	for length >= 68 {
+		dst[i+0] = 63<<2 | tagCopy2
+		dst[i+1] = uint8(offset)
+		dst[i+2] = uint8(offset >> 8)
+		i += 3
+		length -= 64
+	}
+	if length > 64 {
+		// Emit a length-60 copy so that the remainder is at least 4 bytes.
+		dst[i+0] = 59<<2 | tagCopy2
+		dst[i+1] = uint8(offset)
+		dst[i+2] = uint8(offset >> 8)
+		i += 3
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		dst[i+0] = uint8(length-1)<<2 | tagCopy2
+		dst[i+1] = uint8(offset)
+		dst[i+2] = uint8(offset >> 8)
+		return i + 3
+	}
+	dst[i+0] = uint8(offset>>8)<<5 | uint8(length-4)<<2 | tagCopy1
+	dst[i+1] = uint8(offset)
+	return i + 2
+}
+
+// encodeBlock encodes a non-empty src of at most maxBlockSize and at least
+// minNonLiteralBlockSize bytes with a greedy hash-table matcher.
+func encodeBlock(dst, src []byte) (d int) {
+	const (
+		tableBits = 14
+		tableSize = 1 << tableBits
+		tableMask = tableSize - 1
+		shift     = 32 - tableBits
+	)
+	var table [tableSize]uint16
+
+	sLimit := len(src) - inputMargin
+	nextEmit := 0
+	s := 1
+	nextHash := hash(load32(src, s), shift)
+
+	for {
+		// Skip ahead faster the longer we go without a match.
+		skip := 32
+		nextS := s
+		candidate := 0
+		for {
+			s = nextS
+			step := skip >> 5
+			nextS = s + step
+			skip += step
+			if nextS > sLimit {
+				goto emitRemainder
+			}
+			candidate = int(table[nextHash&tableMask])
+			table[nextHash&tableMask] = uint16(s)
+			nextHash = hash(load32(src, nextS), shift)
+			if load32(src, s) == load32(src, candidate) {
+				break
+			}
+		}
+
+		if nextEmit < s {
+			d += emitLiteral(dst[d:], src[nextEmit:s])
+		}
+
+		for {
+			base := s
+			s += 4
+			for i := candidate + 4; s < len(src) && src[i] == src[s]; i, s = i+1, s+1 {
+			}
+			d += emitCopy(dst[d:], base-candidate, s-base)
+			nextEmit = s
+			if s >= sLimit {
+				goto emitRemainder
+			}
+
+			x := load64(src, s-1)
+			prevHash := hash(uint32(x>>0), shift)
+			table[prevHash&tableMask] = uint16(s - 1)
+			currHash := hash(uint32(x>>8), shift)
+			candidate = int(table[currHash&tableMask])
+			table[currHash&tableMask] = uint16(s)
+			if uint32(x>>8) != load32(src, candidate) {
+				nextHash = hash(uint32(x>>16), shift)
+				s++
+				break
+			}
+		}
+	}
+
+emitRemainder:
+	if nextEmit < len(src) {
+		d += emitLiteral(dst[d:], src[nextEmit:])
+	}
+	return d
+}
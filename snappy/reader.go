@@ -0,0 +1,194 @@
+// Copyright 2011 The Snappy-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// Derived from github.com/golang/snappy.
+
+package snappy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// Reader decompresses the snappy framing format. It implements io.Reader
+// and io.ByteScanner and therefore rawpb.Reader. At most one chunk (64 KiB
+// of output) is decoded ahead of the consumer. Buffers are kept across
+// Reset.
+type Reader struct {
+	r       io.Reader
+	err     error
+	decoded []byte // current chunk output
+	buf     []byte // compressed chunk input
+	i, j    int    // decoded[i:j] is unread
+	readHdr bool
+	hdr     [chunkHeaderSize + checksumSize]byte
+}
+
+// NewReader returns a Reader that decompresses the framed stream r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:       r,
+		decoded: make([]byte, maxBlockSize),
+		buf:     make([]byte, maxEncodedBlockSize+checksumSize),
+	}
+}
+
+// Reset discards any state and switches to reading from r.
+func (r *Reader) Reset(rd io.Reader) {
+	r.r = rd
+	r.err = nil
+	r.i = 0
+	r.j = 0
+	r.readHdr = false
+}
+
+func (r *Reader) readFull(p []byte, allowEOF bool) bool {
+	if _, r.err = io.ReadFull(r.r, p); r.err != nil {
+		if r.err == io.ErrUnexpectedEOF || (r.err == io.EOF && !allowEOF) {
+			r.err = ErrCorrupt
+		}
+		return false
+	}
+	return true
+}
+
+// fill reads chunks until decoded output is available.
+func (r *Reader) fill() error {
+	for r.i >= r.j {
+		if r.err != nil {
+			return r.err
+		}
+		if !r.readFull(r.hdr[:chunkHeaderSize], true) {
+			return r.err
+		}
+		chunkType := r.hdr[0]
+		if !r.readHdr {
+			if chunkType != chunkTypeStreamIdentifier {
+				r.err = ErrCorrupt
+				return r.err
+			}
+			r.readHdr = true
+		}
+		chunkLen := int(r.hdr[1]) | int(r.hdr[2])<<8 | int(r.hdr[3])<<16
+		if chunkLen > len(r.buf) {
+			r.err = ErrUnsupported
+			return r.err
+		}
+
+		switch chunkType {
+		case chunkTypeCompressedData:
+			if chunkLen < checksumSize {
+				r.err = ErrCorrupt
+				return r.err
+			}
+			buf := r.buf[:chunkLen]
+			if !r.readFull(buf, false) {
+				return r.err
+			}
+			checksum := binary.LittleEndian.Uint32(buf)
+			buf = buf[checksumSize:]
+
+			n, hdrLen, err := decodedLen(buf)
+			if err != nil {
+				r.err = err
+				return r.err
+			}
+			if n > len(r.decoded) {
+				r.err = ErrCorrupt
+				return r.err
+			}
+			if decode(r.decoded[:n], buf[hdrLen:]) != 0 {
+				r.err = ErrCorrupt
+				return r.err
+			}
+			if crc(r.decoded[:n]) != checksum {
+				r.err = ErrCorrupt
+				return r.err
+			}
+			r.i, r.j = 0, n
+
+		case chunkTypeUncompressedData:
+			if chunkLen < checksumSize {
+				r.err = ErrCorrupt
+				return r.err
+			}
+			if !r.readFull(r.hdr[:checksumSize], false) {
+				return r.err
+			}
+			checksum := binary.LittleEndian.Uint32(r.hdr[:checksumSize])
+			n := chunkLen - checksumSize
+			if n > len(r.decoded) {
+				r.err = ErrCorrupt
+				return r.err
+			}
+			if !r.readFull(r.decoded[:n], false) {
+				return r.err
+			}
+			if crc(r.decoded[:n]) != checksum {
+				r.err = ErrCorrupt
+				return r.err
+			}
+			r.i, r.j = 0, n
+
+		case chunkTypeStreamIdentifier:
+			if chunkLen != len(magicBody) {
+				r.err = ErrCorrupt
+				return r.err
+			}
+			if !r.readFull(r.buf[:len(magicBody)], false) {
+				return r.err
+			}
+			if string(r.buf[:len(magicBody)]) != magicBody {
+				r.err = ErrCorrupt
+				return r.err
+			}
+
+		default:
+			if chunkType <= 0x7f {
+				// Reserved unskippable chunk.
+				r.err = ErrUnsupported
+				return r.err
+			}
+			// Padding and reserved skippable chunks.
+			if !r.readFull(r.buf[:chunkLen], false) {
+				return r.err
+			}
+		}
+	}
+	return nil
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := r.fill(); err != nil {
+		return 0, err
+	}
+	n := copy(p, r.decoded[r.i:r.j])
+	r.i += n
+	return n, nil
+}
+
+// ReadByte implements io.ByteReader.
+func (r *Reader) ReadByte() (byte, error) {
+	if err := r.fill(); err != nil {
+		return 0, err
+	}
+	b := r.decoded[r.i]
+	r.i++
+	return b, nil
+}
+
+// UnreadByte implements io.ByteScanner. Only the last byte of the current
+// chunk can be unread, which is all rawpb needs.
+func (r *Reader) UnreadByte() error {
+	if r.i == 0 {
+		return bufio.ErrInvalidUnreadByte
+	}
+	r.i--
+	return nil
+}
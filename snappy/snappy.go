@@ -0,0 +1,81 @@
+// Copyright 2011 The Snappy-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// Derived from github.com/golang/snappy.
+
+// Package snappy is a dependency-free implementation of the Snappy block
+// and framing formats, tuned for feeding rawpb parsers.
+//
+// Block format (Prometheus remote write bodies):
+//
+//	err := schema.Read(snappy.NewBlockReader(body), alloc)
+//
+// Framing format (streaming variants):
+//
+//	err := schema.Read(snappy.NewReader(conn), alloc)
+//
+// Both readers implement rawpb.Reader and decompress on demand, so bytes
+// flow straight into the parser and the MaxSize option of the schema counts
+// decompressed bytes: a decompression bomb is cut off with
+// rawpb.ErrorTooLarge after at most MaxSize bytes of output instead of
+// being inflated up front. Decode and Encode are available for the
+// one-shot case; all of them reuse caller or reader owned buffers.
+package snappy
+
+import (
+	"errors"
+	"hash/crc32"
+)
+
+var (
+	// ErrCorrupt reports that the input is invalid.
+	ErrCorrupt = errors.New("snappy: corrupt input")
+	// ErrTooLarge reports that the decoded length is too large.
+	ErrTooLarge = errors.New("snappy: decoded block is too large")
+	// ErrUnsupported reports that the framed input uses an unsupported
+	// (reserved unskippable) chunk type.
+	ErrUnsupported = errors.New("snappy: unsupported input")
+)
+
+const (
+	tagLiteral = 0x00
+	tagCopy1   = 0x01
+	tagCopy2   = 0x02
+	tagCopy4   = 0x03
+)
+
+const (
+	// maxBlockSize is the largest block the encoder emits and the largest
+	// uncompressed chunk the framing format allows.
+	maxBlockSize = 65536
+
+	// inputMargin and minNonLiteralBlockSize keep the matcher's 8-byte
+	// loads in bounds.
+	inputMargin            = 16 - 1
+	minNonLiteralBlockSize = 1 + 1 + inputMargin
+)
+
+// Framing format chunk types and stream identifier.
+const (
+	chunkTypeCompressedData   = 0x00
+	chunkTypeUncompressedData = 0x01
+	chunkTypePadding          = 0xfe
+	chunkTypeStreamIdentifier = 0xff
+
+	checksumSize    = 4
+	chunkHeaderSize = 4
+	magicBody       = "sNaPpY"
+	magicChunk      = "\xff\x06\x00\x00" + magicBody
+
+	// maxEncodedBlockSize bounds a compressed chunk body.
+	maxEncodedBlockSize = 32 + maxBlockSize + maxBlockSize/6
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// crc is the masked CRC-32C used by the framing format.
+func crc(b []byte) uint32 {
+	c := crc32.Update(0, crcTable, b)
+	return (c>>15 | c<<17) + 0xa282ead8
+}
@@ -0,0 +1,113 @@
+// Copyright 2011 The Snappy-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// Derived from github.com/golang/snappy.
+
+package snappy
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Writer compresses to the snappy framing format. Data is buffered into
+// 64 KiB chunks; call Flush or Close to emit a partial chunk. Buffers are
+// kept across Reset.
+type Writer struct {
+	w          io.Writer
+	err        error
+	ibuf       []byte // pending uncompressed input
+	obuf       []byte // chunk header + encoded block
+	wroteMagic bool
+}
+
+// NewWriter returns a Writer that compresses to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:    w,
+		ibuf: make([]byte, 0, maxBlockSize),
+		obuf: make([]byte, chunkHeaderSize+checksumSize+maxEncodedBlockSize),
+	}
+}
+
+// Reset discards buffered data and any error and switches to writing to w.
+func (w *Writer) Reset(wr io.Writer) {
+	w.w = wr
+	w.err = nil
+	w.ibuf = w.ibuf[:0]
+	w.wroteMagic = false
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if w.err != nil {
+			return n, w.err
+		}
+		c := copy(w.ibuf[len(w.ibuf):cap(w.ibuf)], p)
+		w.ibuf = w.ibuf[:len(w.ibuf)+c]
+		p = p[c:]
+		n += c
+		if len(w.ibuf) == cap(w.ibuf) {
+			w.writeChunk(w.ibuf)
+			w.ibuf = w.ibuf[:0]
+		}
+	}
+	return n, w.err
+}
+
+// writeChunk emits p (at most maxBlockSize bytes) as a compressed chunk, or
+// as an uncompressed one when compression does not save at least 1/8.
+func (w *Writer) writeChunk(p []byte) {
+	if w.err != nil {
+		return
+	}
+	if !w.wroteMagic {
+		if _, w.err = io.WriteString(w.w, magicChunk); w.err != nil {
+			return
+		}
+		w.wroteMagic = true
+	}
+
+	const headerLen = chunkHeaderSize + checksumSize
+	chunkType := uint8(chunkTypeCompressedData)
+	enc := Encode(w.obuf[headerLen:], p)
+	body := enc
+	if len(enc) >= len(p)-len(p)/8 {
+		chunkType = chunkTypeUncompressedData
+		body = p
+	}
+
+	chunkLen := checksumSize + len(body)
+	hdr := w.obuf[:headerLen]
+	hdr[0] = chunkType
+	hdr[1] = uint8(chunkLen)
+	hdr[2] = uint8(chunkLen >> 8)
+	hdr[3] = uint8(chunkLen >> 16)
+	binary.LittleEndian.PutUint32(hdr[chunkHeaderSize:], crc(p))
+
+	if chunkType == chunkTypeCompressedData {
+		_, w.err = w.w.Write(w.obuf[:headerLen+len(enc)])
+		return
+	}
+	if _, w.err = w.w.Write(hdr); w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(body)
+}
+
+// Flush emits any buffered data as a chunk.
+func (w *Writer) Flush() error {
+	if len(w.ibuf) > 0 {
+		w.writeChunk(w.ibuf)
+		w.ibuf = w.ibuf[:0]
+	}
+	return w.err
+}
+
+// Close flushes buffered data. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	return w.Flush()
+}
@@ -0,0 +1,488 @@
+package rawpb
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+)
+
+// errValueConsumed is set when a length-delimited value is accessed a
+// second time in a different way, e.g. Bytes after Reader. The stream
+// cannot be rewound to serve it.
+var errValueConsumed = errors.New("value already consumed")
+
+// value states of the current length-delimited field
+const (
+	valueUnread = iota
+	valueRead   // Bytes was called, the payload is in slice
+	valueStream // handed to Reader, Submessage or packed continuation
+)
+
+// StreamDecoder is Decoder over an io.Reader: the same Next loop and
+// accessors, for inputs too large to hold in memory. Length-delimited
+// values are copied into memory from an Allocator by Bytes, or read
+// through a length-limited io.Reader returned by Reader; Submessage scopes
+// a decoder to the payload without reading it first.
+//
+//	d := rawpb.NewStreamDecoder(f, &rawpb.LinearAllocator{})
+//	for d.Next() {
+//	    switch d.Num() {
+//	    case 1:
+//	        sub := d.Submessage()
+//	        for sub.Next() { ... }
+//	        if err := sub.Err(); err != nil { return err }
+//	    case 2:
+//	        io.Copy(dst, d.Reader())
+//	    }
+//	}
+//	if err := d.Err(); err != nil { return err }
+//
+// A decoder and its submessages share the stream, so only the innermost
+// one in use may advance: calling Next on a parent skips the rest of the
+// submessage, which then ends. A length-delimited value can be consumed
+// once. Calling Bytes again returns the same slice, but any other second
+// access fails with an error.
+//
+// Packed repeated fields work as with Decoder: a scalar accessor on a
+// length-delimited field returns the first value and each following Next
+// the next one, read from the stream as they are needed.
+//
+// Errors are sticky as in Decoder. Next does not read ahead: after it
+// returns true, the stream is positioned just after the current field's
+// tag and scalar value, or at the start of the length-delimited payload.
+type StreamDecoder struct {
+	r      *readerLimit // shared with submessages
+	end    uint64       // stream offset where this message ends
+	nested bool         // end is a length, not a size cap
+
+	start  uint64 // stream offset of the current tag
+	next   uint64 // stream offset after the current value
+	num    int
+	wt     int
+	scalar uint64
+	length uint64 // payload length of a length-delimited field
+	slice  []byte
+	state  int
+	packed bool
+	err    error
+}
+
+// NewStreamDecoder returns a StreamDecoder over stream. Bytes copies
+// length-delimited values into memory from mem; a nil mem means a
+// HeapAllocator. stream is wrapped in a bufio.Reader unless it already is
+// a Reader.
+func NewStreamDecoder(stream io.Reader, mem Allocator) *StreamDecoder {
+	d := &StreamDecoder{}
+	d.Reset(stream, mem)
+	return d
+}
+
+// Reset rebinds the decoder to a new stream and clears prior state,
+// including the size cap.
+func (d *StreamDecoder) Reset(stream io.Reader, mem Allocator) {
+	if mem == nil {
+		mem = &HeapAllocator{}
+	}
+	w, ok := stream.(Reader)
+	if !ok {
+		w = bufio.NewReader(stream)
+	}
+	r := d.r
+	if r == nil {
+		r = &readerLimit{}
+	}
+	*r = readerLimit{w: w, mem: mem}
+	*d = StreamDecoder{r: r, end: math.MaxUint64}
+}
+
+// SetMaxSize caps the number of bytes the decoder reads from the stream,
+// like the MaxSize option of Read. A stream with more than n bytes fails
+// with ErrorTooLarge, and no Bytes call allocates more than n bytes. Zero
+// removes the cap. Call it before the first Next.
+func (d *StreamDecoder) SetMaxSize(n uint64) {
+	d.r.max = n
+	d.end = n
+	if n == 0 {
+		d.end = math.MaxUint64
+	}
+}
+
+// Err returns the first error encountered by Next or by an accessor.
+func (d *StreamDecoder) Err() error {
+	return d.err
+}
+
+// Num returns the field number of the current field.
+func (d *StreamDecoder) Num() int {
+	return d.num
+}
+
+// WireType returns the wire type of the current field, see
+// Decoder.WireType.
+func (d *StreamDecoder) WireType() int {
+	return d.wt
+}
+
+// Offset returns the stream offset of the current field's tag, counted
+// from where the top-level decoder started reading.
+func (d *StreamDecoder) Offset() uint64 {
+	return d.start
+}
+
+// Next advances to the next field, skipping whatever the caller left
+// unread of the current one. Returns false at the end of the message or
+// after an error (see Err).
+func (d *StreamDecoder) Next() bool {
+	if d.err != nil || d.r == nil {
+		return false
+	}
+	r := d.r
+	if d.packed {
+		if r.read < d.next {
+			return d.nextPackedValue()
+		}
+		d.packed = false
+	}
+	if r.read < d.next {
+		r.limit = d.next - r.read
+		if err := r.skip(d.next - r.read); err != nil {
+			return d.fail(err)
+		}
+	}
+	if r.read > d.end {
+		// a parent moved past this submessage
+		return false
+	}
+
+	d.start = r.read
+	r.limit = d.end - r.read
+	tag, ok, err := r.tag()
+	if err != nil {
+		return d.fail(err)
+	}
+	if !ok {
+		if d.nested && r.read < d.end {
+			return d.fail(ErrorTruncated)
+		}
+		return false
+	}
+	num, wt, err := parseTag(tag)
+	if err != nil {
+		return d.fail(err)
+	}
+	d.num = num
+	d.wt = wt
+	d.slice = nil
+
+	switch wt {
+	case WireVarint:
+		d.scalar, err = r.varint()
+	case WireFixed64:
+		d.scalar, err = r.fixed64()
+	case WireFixed32:
+		var v uint32
+		v, err = r.fixed32()
+		d.scalar = uint64(v)
+	case WireLen:
+		d.length, err = r.varint()
+		if err == nil && d.length > d.end-r.read {
+			err = r.short(d.length)
+		}
+		d.state = valueUnread
+	default:
+		err = ErrorWrongWireType
+	}
+	if err != nil {
+		return d.fail(err)
+	}
+	d.next = r.read
+	if wt == WireLen {
+		d.next += d.length
+	}
+	return true
+}
+
+// fail makes err sticky.
+func (d *StreamDecoder) fail(err error) bool {
+	d.err = err
+	return false
+}
+
+// nextPackedValue reads the next value of the packed payload.
+func (d *StreamDecoder) nextPackedValue() bool {
+	v, err := d.readScalar()
+	if err != nil {
+		return d.fail(err)
+	}
+	d.scalar = v
+	return true
+}
+
+// readScalar reads one value of wire type d.wt from the packed payload.
+func (d *StreamDecoder) readScalar() (uint64, error) {
+	r := d.r
+	r.limit = d.next - r.read
+	switch d.wt {
+	case WireFixed64:
+		return r.fixed64()
+	case WireFixed32:
+		v, err := r.fixed32()
+		return uint64(v), err
+	}
+	return r.varint()
+}
+
+// unpack starts packed continuation over the current length-delimited
+// field, with values of wire type wt and fixed size size (0 for varints).
+// ok is false when there is no first value to return.
+func (d *StreamDecoder) unpack(wt int, size uint64) (uint64, bool) {
+	if d.length == 0 {
+		return 0, false
+	}
+	if d.state != valueUnread {
+		d.err = errValueConsumed
+		return 0, false
+	}
+	if size > 0 && d.length%size != 0 {
+		d.err = ErrorInvalidMessage
+		return 0, false
+	}
+	d.state = valueStream
+	d.packed = true
+	d.wt = wt
+	v, err := d.readScalar()
+	if err != nil {
+		d.fail(err)
+		return 0, false
+	}
+	d.scalar = v
+	return v, true
+}
+
+// --- Scalar accessors ---
+
+// Uint64 returns the current varint value. On a length-delimited field it
+// starts packed continuation, see Decoder.Uint64.
+func (d *StreamDecoder) Uint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	switch d.wt {
+	case WireVarint:
+		return d.scalar
+	case WireLen:
+		v, _ := d.unpack(WireVarint, 0)
+		return v
+	}
+	d.err = ErrorWrongWireType
+	return 0
+}
+
+// Int64 returns the current varint value reinterpreted as int64.
+func (d *StreamDecoder) Int64() int64 { return int64(d.Uint64()) }
+
+// Uint32 returns the current varint value truncated to uint32.
+func (d *StreamDecoder) Uint32() uint32 { return uint32(d.Uint64()) }
+
+// Int32 returns the current varint value reinterpreted as int32.
+func (d *StreamDecoder) Int32() int32 { return int32(d.Uint64()) }
+
+// Bool returns the current varint value != 0.
+func (d *StreamDecoder) Bool() bool { return d.Uint64() != 0 }
+
+// Sint32 decodes the current varint using zigzag encoding.
+func (d *StreamDecoder) Sint32() int32 {
+	u := d.Uint32()
+	return int32(u>>1) ^ -int32(u&1)
+}
+
+// Sint64 decodes the current varint using zigzag encoding.
+func (d *StreamDecoder) Sint64() int64 {
+	u := d.Uint64()
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+// Fixed64 returns the current 64-bit fixed value. On a length-delimited
+// field it starts packed continuation, see Decoder.Fixed64.
+func (d *StreamDecoder) Fixed64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	switch d.wt {
+	case WireFixed64:
+		return d.scalar
+	case WireLen:
+		v, _ := d.unpack(WireFixed64, 8)
+		return v
+	}
+	d.err = ErrorWrongWireType
+	return 0
+}
+
+// Sfixed64 returns the current 64-bit fixed value as int64.
+func (d *StreamDecoder) Sfixed64() int64 { return int64(d.Fixed64()) }
+
+// Double returns the current 64-bit fixed value as float64.
+func (d *StreamDecoder) Double() float64 { return math.Float64frombits(d.Fixed64()) }
+
+// Fixed32 returns the current 32-bit fixed value. On a length-delimited
+// field it starts packed continuation, see Decoder.Fixed32.
+func (d *StreamDecoder) Fixed32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+	switch d.wt {
+	case WireFixed32:
+		return uint32(d.scalar)
+	case WireLen:
+		v, _ := d.unpack(WireFixed32, 4)
+		return uint32(v)
+	}
+	d.err = ErrorWrongWireType
+	return 0
+}
+
+// Sfixed32 returns the current 32-bit fixed value as int32.
+func (d *StreamDecoder) Sfixed32() int32 { return int32(d.Fixed32()) }
+
+// Float returns the current 32-bit fixed value as float32.
+func (d *StreamDecoder) Float() float32 { return math.Float32frombits(d.Fixed32()) }
+
+// --- Length-delimited accessors ---
+
+// Bytes reads the payload of the current length-delimited field into
+// memory from the decoder's Allocator. The slice stays valid as long as
+// the allocator keeps it; calling Bytes again returns the same slice.
+func (d *StreamDecoder) Bytes() []byte {
+	if d.err != nil {
+		return nil
+	}
+	if d.wt != WireLen {
+		d.err = ErrorWrongWireType
+		return nil
+	}
+	switch d.state {
+	case valueRead:
+		return d.slice
+	case valueStream:
+		d.err = errValueConsumed
+		return nil
+	}
+	d.r.limit = d.next - d.r.read
+	b, err := d.r.bytes(d.length)
+	if err != nil {
+		d.fail(err)
+		return nil
+	}
+	d.state = valueRead
+	d.slice = b
+	return b
+}
+
+// UnsafeString returns the payload as a string aliasing the memory Bytes
+// returns.
+func (d *StreamDecoder) UnsafeString() string {
+	b := d.Bytes()
+	if b == nil {
+		return ""
+	}
+	return unsafeString(b)
+}
+
+// CopyString returns the payload as a heap-copied string.
+func (d *StreamDecoder) CopyString() string {
+	b := d.Bytes()
+	if b == nil {
+		return ""
+	}
+	return string(b)
+}
+
+// Len returns the payload length of the current length-delimited field,
+// without reading it.
+func (d *StreamDecoder) Len() uint64 {
+	if d.wt != WireLen {
+		return 0
+	}
+	return d.length
+}
+
+// Reader returns the payload of the current length-delimited field as a
+// stream of exactly Len bytes, for values too large to hold in memory.
+// It is valid until the next call to Next, which skips whatever the caller
+// did not read. If the underlying stream ends early, Read returns
+// io.ErrUnexpectedEOF.
+func (d *StreamDecoder) Reader() io.Reader {
+	if d.err != nil {
+		return eofReader{}
+	}
+	if d.wt != WireLen {
+		d.err = ErrorWrongWireType
+		return eofReader{}
+	}
+	if d.state != valueUnread {
+		d.err = errValueConsumed
+		return eofReader{}
+	}
+	d.state = valueStream
+	return &fieldReader{r: d.r, end: d.next}
+}
+
+// Submessage returns a StreamDecoder scoped to the payload of the current
+// length-delimited field. It reads from the parent's stream, so the
+// parent must not advance until the submessage is done with.
+//
+// If the current field is not length-delimited, ErrorWrongWireType is set
+// on the parent and an empty sub-decoder is returned.
+func (d *StreamDecoder) Submessage() StreamDecoder {
+	if d.err != nil {
+		return StreamDecoder{}
+	}
+	if d.wt != WireLen {
+		d.err = ErrorWrongWireType
+		return StreamDecoder{}
+	}
+	if d.state != valueUnread {
+		d.err = errValueConsumed
+		return StreamDecoder{}
+	}
+	d.state = valueStream
+	return StreamDecoder{
+		r:      d.r,
+		end:    d.next,
+		nested: true,
+		start:  d.r.read,
+		next:   d.r.read,
+	}
+}
+
+// fieldReader reads the payload of one length-delimited field, up to the
+// stream offset end.
+type fieldReader struct {
+	r   *readerLimit
+	end uint64
+}
+
+func (f *fieldReader) Read(p []byte) (int, error) {
+	r := f.r
+	if r.read >= f.end {
+		return 0, io.EOF
+	}
+	if rest := f.end - r.read; uint64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := r.w.Read(p)
+	r.read += uint64(n)
+	if err == io.EOF {
+		if r.read < f.end {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
@@ -0,0 +1,275 @@
+package rawpb
+
+import "io"
+
+// wireSource is the input of the field engine. readerBody implements it
+// over a byte slice and readerLimit over a stream; everything else
+// (dispatch, validation, error wrapping) lives in parseMessage, so Parse
+// and Read cannot drift apart.
+type wireSource interface {
+	// tag reads the next tag. ok is false at the end of the current
+	// message.
+	tag() (tag uint64, ok bool, err error)
+	varint() (uint64, error)
+	fixed64() (uint64, error)
+	fixed32() (uint32, error)
+	bytes(n uint64) ([]byte, error)
+	skip(n uint64) error
+	// reader hands the next n bytes to f as an io.Reader, then moves past
+	// whatever f left unread.
+	reader(n uint64, f func(r io.Reader, n uint64) error) error
+	// raw returns the complete encoding of the field whose tag was just
+	// read, tag included, and moves past its value.
+	raw(wt int) ([]byte, error)
+	// push limits the source to the next n bytes, for a submessage or a
+	// packed payload, and pop restores the limit push returned once those
+	// bytes were consumed.
+	push(n uint64) (uint64, error)
+	pop(saved uint64)
+	// more reports whether the pushed region has bytes left.
+	more() bool
+}
+
+// parseMessage runs pb over the current message of s.
+func parseMessage[S wireSource](pb *RawPB, s S) error {
+	if pb.beginFunc != nil {
+		if err := pb.beginFunc(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		tag, ok, err := s.tag()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		num, wt, err := parseTag(tag)
+		if err != nil {
+			return pb.wrapError(num, err)
+		}
+		if wt != WireVarint && wt != WireFixed64 && wt != WireLen && wt != WireFixed32 {
+			return ErrorWrongWireType
+		}
+		c := pb.schema.lookup(num)
+		if c.tp == callbackTypeRaw {
+			err = parseRaw(c, s, wt)
+		} else {
+			err = parseField(pb, s, c, num, wt)
+		}
+		if err != nil {
+			return pb.wrapError(num, err)
+		}
+	}
+
+	if pb.endFunc != nil {
+		if err := pb.endFunc(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseField reads the value of one field and dispatches it to c, the
+// callback registered for num.
+func parseField[S wireSource](pb *RawPB, s S, c *callback, num, wt int) error {
+	switch wt {
+	case WireVarint:
+		v, err := s.varint()
+		if err != nil {
+			return err
+		}
+		return pb.schema.varint(c, num, v)
+	case WireFixed64:
+		v, err := s.fixed64()
+		if err != nil {
+			return err
+		}
+		return pb.schema.fixed64(c, num, v)
+	case WireFixed32:
+		v, err := s.fixed32()
+		if err != nil {
+			return err
+		}
+		return pb.schema.fixed32(c, num, v)
+	case WireLen:
+		l, err := s.varint()
+		if err != nil {
+			return err
+		}
+		return parseLen(pb, s, c, num, l)
+	}
+	panic("unknown wire type")
+}
+
+// parseLen handles a length-delimited payload of l bytes: bytes,
+// submessage or packed scalars, depending on the field callback.
+func parseLen[S wireSource](pb *RawPB, s S, c *callback, num int, l uint64) error {
+	switch c.tp {
+	case callbackTypeNone:
+		if pb.schema.unknown.bytes == nil {
+			return s.skip(l)
+		}
+		v, err := s.bytes(l)
+		if err != nil {
+			return err
+		}
+		return pb.schema.unknown.bytes(num, v)
+	case callbackTypeBytes:
+		if c.funcReader != nil {
+			return s.reader(l, c.funcReader)
+		}
+		if c.funcBytes == nil {
+			return s.skip(l)
+		}
+		v, err := s.bytes(l)
+		if err != nil {
+			return err
+		}
+		return c.funcBytes(v)
+	case callbackTypeMessage:
+		if c.message == nil {
+			return s.skip(l)
+		}
+		if m := c.message.maxSize; m > 0 && l > m {
+			return ErrorTooLarge
+		}
+		saved, err := s.push(l)
+		if err != nil {
+			return err
+		}
+		if err = parseMessage(c.message, s); err != nil {
+			return err
+		}
+		s.pop(saved)
+		return nil
+	}
+
+	// packed scalars
+	if c.funcPacked != nil {
+		v, err := s.bytes(l)
+		if err != nil {
+			return err
+		}
+		return c.funcPacked(v)
+	}
+	saved, err := s.push(l)
+	if err != nil {
+		return err
+	}
+	for s.more() {
+		switch c.tp {
+		case callbackTypeVarint:
+			var v uint64
+			if v, err = s.varint(); err == nil {
+				err = call(c.funcUint64, v)
+			}
+		case callbackTypeFixed64:
+			var v uint64
+			if v, err = s.fixed64(); err == nil {
+				err = call(c.funcUint64, v)
+			}
+		case callbackTypeFixed32:
+			var v uint32
+			if v, err = s.fixed32(); err == nil {
+				err = call(c.funcUint32, v)
+			}
+		default:
+			panic("unknown callback type")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.pop(saved)
+	return nil
+}
+
+// parseRaw hands the encoded field to a Raw callback.
+func parseRaw[S wireSource](c *callback, s S, wt int) error {
+	v, err := s.raw(wt)
+	if err != nil {
+		return err
+	}
+	return call(c.funcBytes, v)
+}
+
+// parseTag splits a tag into field number and wire type. Field numbers
+// outside [1, maxFieldNumber] are rejected with ErrorInvalidMessage; the
+// wire type is left for the caller to check.
+func parseTag(tag uint64) (num, wt int, err error) {
+	num = int(tag >> 3)
+	if num < 1 || num > maxFieldNumber {
+		return num, 0, ErrorInvalidMessage
+	}
+	return num, int(tag & 7), nil
+}
+
+// decodeVarint reads a varint from the start of b, returning the value and
+// the number of bytes consumed. Rejects varints longer than 10 bytes.
+func decodeVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	limit := len(b)
+	if limit > maxVarintBytes {
+		limit = maxVarintBytes
+	}
+	for i := 0; i < limit; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i]&0x80 == 0 {
+			return v, i + 1, nil
+		}
+	}
+	if len(b) < maxVarintBytes {
+		return 0, 0, ErrorTruncated
+	}
+	return 0, 0, ErrorInvalidMessage
+}
+
+// valueSize returns the encoded size of a value of wire type wt at the
+// start of b, including the length prefix of a length-delimited value.
+func valueSize(b []byte, wt int) (int, error) {
+	switch wt {
+	case WireVarint:
+		_, n, err := decodeVarint(b)
+		return n, err
+	case WireFixed64:
+		if len(b) < 8 {
+			return 0, ErrorTruncated
+		}
+		return 8, nil
+	case WireFixed32:
+		if len(b) < 4 {
+			return 0, ErrorTruncated
+		}
+		return 4, nil
+	case WireLen:
+		l, n, err := decodeVarint(b)
+		if err != nil {
+			return 0, err
+		}
+		if l > uint64(len(b)-n) {
+			return 0, ErrorTruncated
+		}
+		return n + int(l), nil
+	}
+	return 0, ErrorWrongWireType
+}
+
+// decodeFixed64 reads a little-endian fixed64 from the start of b.
+func decodeFixed64(b []byte) (uint64, error) {
+	if len(b) < 8 {
+		return 0, ErrorTruncated
+	}
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56, nil
+}
+
+// decodeFixed32 reads a little-endian fixed32 from the start of b.
+func decodeFixed32(b []byte) (uint32, error) {
+	if len(b) < 4 {
+		return 0, ErrorTruncated
+	}
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24, nil
+}
@@ -1,31 +1,93 @@
 package rawpb
 
 import (
-	"bytes"
+	"fmt"
 	"io"
 	"math"
+	"slices"
+	"strconv"
+	"sync"
 	"unsafe"
 )
 
-const (
-	wireVarint = 0
-	wireI64    = 1
-	wireLen    = 2
-	wireI32    = 5
-)
-
 // Writer implements a low-level protocol buffer writer without code generation
+//
+// Fields are encoded into an internal buffer with the Append functions, so
+// writing a field costs no I/O. The buffer goes to the underlying writer
+// when it grows past writerFlushSize between top-level fields, and on
+// Flush, which callers of NewWriter must call when done. Write flushes for
+// its callback.
+//
+// Submessages are encoded in place: Message reserves a byte for the
+// length, lets the callback append the body behind it and patches the
+// length in, moving the body only when the length needs more than one
+// byte.
+//
+// Field numbers are checked: the first field out of range stops the
+// Writer with an error wrapping ErrorInvalidField, and Flush and Err
+// report it.
+//
+// A Writer created by Size encodes nothing and only counts bytes.
 type Writer struct {
-	wrap      io.Writer
-	buf       [10]byte
-	subBuffer bytes.Buffer
-	subWriter *Writer
-	err       error
+	wrap  io.Writer
+	enc   Encoder
+	depth int // number of open submessages
+	err   error
+
+	sizing bool // fields go to sizer instead, see Size
+	sizer  sizer
+
+	omitDefaults  bool
+	deterministic bool
+
+	debug bool
+	path  []int // numbers of the open submessages, with debug
+}
+
+// WriterOption configures a Writer
+type WriterOption func(*Writer)
+
+// OmitDefaults makes the Writer skip scalar fields with the zero value and
+// empty strings and bytes, as proto3 serializers do for fields without
+// presence. Negative zero floats are still written. Submessages, packed
+// fields and Raw are not affected; fields that track presence are written
+// with the Optional methods.
+func OmitDefaults() WriterOption {
+	return func(w *Writer) {
+		w.omitDefaults = true
+	}
+}
+
+// Debug makes the Writer prefix its error with the path of the field that
+// failed, as in "field 17.3.0: invalid field: ...", counting from the top
+// level through the enclosing Message calls. It also checks that Raw is
+// given well-formed fields, which costs a decode of every Raw input.
+func Debug() WriterOption {
+	return func(w *Writer) {
+		w.debug = true
+	}
+}
+
+// writerFlushSize is the buffered size past which Writer writes to the
+// underlying writer without waiting for Flush. Bytes and String values of
+// at least this size are written directly instead of being copied.
+const writerFlushSize = 32 << 10
+
+// writerPoolMaxBuffer is the largest buffer a Writer may keep to go back
+// to writerPool, so that one huge message does not pin its buffer.
+const writerPoolMaxBuffer = 1 << 20
+
+var writerPool = sync.Pool{
+	New: func() any { return new(Writer) },
 }
 
 // Write proto message
-func Write(out io.Writer, cb func(w *Writer) error) error {
-	w := NewWriter(out)
+//
+// The Writer comes from a pool and goes back to it when Write returns, so
+// cb must not keep it. After warmup Write does not allocate.
+func Write(out io.Writer, cb func(w *Writer) error, opts ...WriterOption) error {
+	w := getWriter(out, opts)
+	defer putWriter(w)
 
 	err := cb(w)
 
@@ -33,149 +95,351 @@ func Write(out io.Writer, cb func(w *Writer) error) error {
 		return err
 	}
 
-	return w.Err()
+	return w.Flush()
 }
 
 // NewWriter creates a new Writer instance
-func NewWriter(w io.Writer) *Writer {
-	return &Writer{
-		wrap: w,
-	}
+func NewWriter(w io.Writer, opts ...WriterOption) *Writer {
+	wr := &Writer{}
+	wr.init(w, opts)
+	return wr
 }
 
-// Message writes a protocol buffer submessage using a callback function
-func (w *Writer) Message(num int, cb func(w *Writer) error) {
-	if w.err != nil || cb == nil {
+func getWriter(out io.Writer, opts []WriterOption) *Writer {
+	w := writerPool.Get().(*Writer)
+	w.init(out, opts)
+	return w
+}
+
+func putWriter(w *Writer) {
+	if cap(w.enc.buf) > writerPoolMaxBuffer {
 		return
 	}
+	w.Reset(nil)
+	writerPool.Put(w)
+}
 
-	if w.subWriter == nil {
-		w.subWriter = NewWriter(&w.subBuffer)
+// init resets w and replaces its options with opts.
+func (w *Writer) init(out io.Writer, opts []WriterOption) {
+	w.Reset(out)
+	w.sizing = false
+	w.omitDefaults = false
+	w.deterministic = false
+	w.debug = false
+	for _, o := range opts {
+		o(w)
 	}
+}
 
-	w.subBuffer.Reset()
+// Reset discards the buffered fields and the error of w and makes it write
+// to out, keeping its options and its buffer. A Writer that is reset for
+// every message encodes without allocating once the buffer has grown, for
+// example from a pool:
+//
+//	var writers = sync.Pool{New: func() any { return rawpb.NewWriter(nil) }}
+//
+//	w := writers.Get().(*rawpb.Writer)
+//	w.Reset(conn)
+//	encode(w)
+//	err := w.Flush()
+//	w.Reset(nil)
+//	writers.Put(w)
+//
+// Reset must not be called inside a Message callback.
+func (w *Writer) Reset(out io.Writer) {
+	w.wrap = out
+	w.enc.Reset(w.enc.buf[:0])
+	w.depth = 0
+	w.err = nil
+	w.path = w.path[:0]
+	w.sizer.n = 0
+}
 
-	err := cb(w.subWriter)
-	if err != nil {
-		w.err = err
-		return
+// Flush writes the buffered fields to the underlying writer. It returns
+// the first error of the Writer. Flush inside a Message callback does
+// nothing, since the submessage is not finished.
+func (w *Writer) Flush() error {
+	if w.err != nil || w.depth > 0 {
+		return w.err
+	}
+	if len(w.enc.buf) > 0 {
+		_, w.err = w.wrap.Write(w.enc.buf)
+		w.enc.buf = w.enc.buf[:0]
+	}
+	return w.err
+}
+
+// Buffered returns the number of bytes encoded but not yet written to the
+// underlying writer.
+func (w *Writer) Buffered() int {
+	return len(w.enc.buf)
+}
+
+// written flushes the buffer once it is large, between top-level fields.
+func (w *Writer) written() {
+	if w.depth == 0 && len(w.enc.buf) >= writerFlushSize {
+		w.Flush()
 	}
+}
 
-	if w.subWriter.err != nil {
-		w.err = w.subWriter.err
+// Message writes a protocol buffer submessage using a callback function.
+// The callback receives w itself; whatever it writes goes into the
+// submessage. Nothing of the submessage is written if the callback fails.
+func (w *Writer) Message(num int, cb func(w *Writer) error) {
+	if cb == nil || !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizeMessage(num, cb)
 		return
 	}
 
-	w.Bytes(num, w.subBuffer.Bytes())
+	m := w.enc.beginMessage(num)
+	if w.callback(num, cb) != nil {
+		w.enc.cancelMessage(m)
+		return
+	}
+	w.enc.endMessage(m)
+	w.written()
 }
 
-func (w *Writer) writeVarint(v uint64) error {
-	n := 0
-	for v >= 1<<7 {
-		w.buf[n] = byte(v&0x7f | 0x80)
-		v >>= 7
-		n++
+// sizeMessage is Message for a Writer run by Size.
+func (w *Writer) sizeMessage(num int, cb func(w *Writer) error) {
+	start := w.sizer.n
+	if w.callback(num, cb) != nil {
+		return
 	}
-	w.buf[n] = byte(v)
+	w.sizer.n += SizeTag(num) + SizeVarint(uint64(w.sizer.n-start))
+}
 
-	if _, err := w.wrap.Write(w.buf[:n+1]); err != nil {
-		w.err = err
-		return err
+// callback runs the callback of submessage num and returns the error of
+// the Writer after it.
+func (w *Writer) callback(num int, cb func(w *Writer) error) error {
+	w.depth++
+	if w.debug {
+		w.path = append(w.path, num)
 	}
-
-	return nil
+	err := cb(w)
+	w.depth--
+	if w.debug {
+		w.path = w.path[:len(w.path)-1]
+	}
+	if err != nil && err != w.err {
+		w.fail(err, num)
+	}
+	return w.err
 }
 
-func (w *Writer) writeTag(num int, wt int) error {
+// field reports whether field num can be written, setting the error of
+// the Writer if num is out of range.
+func (w *Writer) field(num int) bool {
 	if w.err != nil {
-		return w.err
+		return false
 	}
-
-	tag := (uint64(num) << 3) | uint64(wt)
-	if err := w.writeVarint(tag); err != nil {
-		w.err = err
-		return err
+	if num < 1 || num > maxFieldNumber {
+		w.invalidNumber(num)
+		return false
 	}
+	return true
+}
+
+func (w *Writer) invalidNumber(num int) {
+	w.fail(fmt.Errorf("%w: number %d out of range [1, %d]", ErrorInvalidField, num, maxFieldNumber), num)
+}
 
-	return nil
+// fail sets the error of the Writer. With debug the error is prefixed
+// with the path of the open submessages followed by nums.
+func (w *Writer) fail(err error, nums ...int) {
+	if w.debug && len(w.path)+len(nums) > 0 {
+		var b []byte
+		for _, n := range append(w.path[:len(w.path):len(w.path)], nums...) {
+			if len(b) > 0 {
+				b = append(b, '.')
+			}
+			b = strconv.AppendInt(b, int64(n), 10)
+		}
+		err = fmt.Errorf("field %s: %w", b, err)
+	}
+	w.err = err
 }
 
 // Bytes writes a length-delimited byte slice field
 func (w *Writer) Bytes(num int, v []byte) {
-	if err := w.writeTag(num, wireLen); err != nil {
+	if len(v) == 0 && w.omitDefaults {
 		return
 	}
+	w.bytes(num, v)
+}
 
-	if err := w.writeVarint(uint64(len(v))); err != nil {
-		w.err = err
+func (w *Writer) bytes(num int, v []byte) {
+	if !w.field(num) {
+		return
+	}
+	if w.sizing {
+		w.sizer.Bytes(num, v)
 		return
 	}
-	if len(v) > 0 {
-		if _, err := w.wrap.Write(v); err != nil {
-			w.err = err
+	if w.depth == 0 && len(v) >= writerFlushSize {
+		w.enc.buf = AppendTag(w.enc.buf, num, WireLen)
+		w.enc.buf = AppendVarint(w.enc.buf, uint64(len(v)))
+		if w.Flush() == nil {
+			_, w.err = w.wrap.Write(v)
 		}
+		return
+	}
+	w.enc.Bytes(num, v)
+	w.written()
+}
+
+// BytesFrom writes a bytes field holding the next n bytes of r. At the
+// top level large values are copied from r to the underlying writer
+// without buffering; inside Message they are read into the buffer, since
+// the lengths of the enclosing submessages are not known yet. If r ends
+// early the Writer fails with io.ErrUnexpectedEOF. Size counts the field
+// without reading r.
+func (w *Writer) BytesFrom(num int, r io.Reader, n int64) {
+	if n == 0 && w.omitDefaults {
+		return
+	}
+	if !w.field(num) {
+		return
+	}
+	if n < 0 || uint64(n) > math.MaxInt {
+		w.fail(fmt.Errorf("%w: length %d out of range", ErrorInvalidField, n), num)
+		return
+	}
+	if w.sizing {
+		w.sizer.n += SizeTag(num) + SizeBytes(int(n))
+		return
+	}
+
+	mark := len(w.enc.buf)
+	w.enc.buf = AppendTag(w.enc.buf, num, WireLen)
+	w.enc.buf = AppendVarint(w.enc.buf, uint64(n))
+	if w.depth == 0 && n >= writerFlushSize {
+		if w.Flush() != nil {
+			return
+		}
+		if _, err := io.CopyN(w.wrap, r, n); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			w.fail(err, num)
+		}
+		return
+	}
+
+	start := len(w.enc.buf)
+	w.enc.buf = slices.Grow(w.enc.buf, int(n))[:start+int(n)]
+	if _, err := io.ReadFull(r, w.enc.buf[start:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		w.enc.buf = w.enc.buf[:mark]
+		w.fail(err, num)
+		return
+	}
+	w.written()
+}
+
+// Raw writes already encoded fields as they are, for example the output
+// of Decoder.RawField or of a Raw callback. It does not validate encoded
+// unless the Writer was created with Debug.
+func (w *Writer) Raw(encoded []byte) {
+	if w.err != nil {
+		return
+	}
+	if w.debug && !w.checkRaw(encoded) {
+		return
+	}
+	if w.sizing {
+		w.sizer.Raw(encoded)
+		return
+	}
+	w.enc.Raw(encoded)
+	w.written()
+}
+
+// checkRaw decodes encoded for Raw with debug.
+func (w *Writer) checkRaw(encoded []byte) bool {
+	var d Decoder
+	d.Reset(encoded)
+	for d.Next() {
+	}
+	if err := d.Err(); err != nil {
+		w.fail(fmt.Errorf("%w: Raw at offset %d: %w", ErrorInvalidField, d.Offset(), err))
+		return false
 	}
+	return true
 }
 
 // Fixed64 writes a 64-bit fixed-size field
 func (w *Writer) Fixed64(num int, v uint64) {
-	if err := w.writeTag(num, wireI64); err != nil {
+	if v == 0 && w.omitDefaults {
 		return
 	}
+	w.fixed64(num, v)
+}
 
-	for i := 0; i < 8; i++ {
-		w.buf[i] = byte(v >> (i * 8))
+func (w *Writer) fixed64(num int, v uint64) {
+	if !w.field(num) {
+		return
 	}
-	if _, err := w.wrap.Write(w.buf[:8]); err != nil {
-		w.err = err
+	if w.sizing {
+		w.sizer.Fixed64(num, v)
+		return
 	}
+	w.enc.Fixed64(num, v)
+	w.written()
 }
 
 // Fixed32 writes a 32-bit fixed-size field
 func (w *Writer) Fixed32(num int, v uint32) {
-	if err := w.writeTag(num, wireI32); err != nil {
+	if v == 0 && w.omitDefaults {
 		return
 	}
+	w.fixed32(num, v)
+}
 
-	for i := 0; i < 4; i++ {
-		w.buf[i] = byte(v >> (i * 8))
+func (w *Writer) fixed32(num int, v uint32) {
+	if !w.field(num) {
+		return
 	}
-	if _, err := w.wrap.Write(w.buf[:4]); err != nil {
-		w.err = err
+	if w.sizing {
+		w.sizer.Fixed32(num, v)
+		return
 	}
+	w.enc.Fixed32(num, v)
+	w.written()
 }
 
 // Uint64 writes an unsigned 64-bit integer field
 func (w *Writer) Uint64(num int, v uint64) {
-	if err := w.writeTag(num, wireVarint); err != nil {
+	if v == 0 && w.omitDefaults {
 		return
 	}
-
-	if err := w.writeVarint(v); err != nil {
-		w.err = err
-	}
+	w.uint64(num, v)
 }
 
-// String writes a string field
-func (w *Writer) String(num int, v string) {
-	if err := w.writeTag(num, wireLen); err != nil {
+func (w *Writer) uint64(num int, v uint64) {
+	if !w.field(num) {
 		return
 	}
-
-	if err := w.writeVarint(uint64(len(v))); err != nil {
-		w.err = err
+	if w.sizing {
+		w.sizer.Uint64(num, v)
 		return
 	}
-	if len(v) > 0 {
-		// unsafe string to bytes
-		dataPtr := unsafe.StringData(v)
-		// Create a byte slice from the pointer and string length
-		byteSlice := unsafe.Slice(dataPtr, len(v))
+	w.enc.Uint64(num, v)
+	w.written()
+}
 
-		if _, err := w.wrap.Write(byteSlice); err != nil {
-			w.err = err
-		}
-	}
+// String writes a string field
+func (w *Writer) String(num int, v string) {
+	w.Bytes(num, unsafe.Slice(unsafe.StringData(v), len(v)))
+}
+
+func (w *Writer) string(num int, v string) {
+	w.bytes(num, unsafe.Slice(unsafe.StringData(v), len(v)))
 }
 
 // Int64 writes a signed 64-bit integer field
@@ -237,7 +501,11 @@ func (w *Writer) Sfixed32(num int, v int32) {
 	w.Fixed32(num, uint32(v))
 }
 
-// Err ...
+// Err returns the first error of w, which stops it: a field it cannot
+// encode, wrapping ErrorInvalidField, an error returned by a Message
+// callback, or a write error of the underlying writer. With Debug the
+// error is prefixed with the path of the failed field, as in
+// "field 17.3.0: ...". Reset clears it.
 func (w *Writer) Err() error {
 	return w.err
 }